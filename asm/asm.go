@@ -0,0 +1,488 @@
+// Package asm converts between Monkey bytecode and a textual listing of it.
+//
+// A listing contains the instructions of the main program, one per line,
+// in the format code.Instructions.String produces. The offset in front of
+// an instruction is optional and ignored, so disassembled code can be
+// edited freely. Everything after a ';' is a comment.
+//
+//	; prints the sum of 1 and 2
+//	.const one int 1
+//	.const int 2
+//	0000 OpConstant one
+//	0003 OpConstant 1
+//	0006 OpAdd
+//	0007 OpPop
+//
+// Constants are declared with .const and added to the constant pool in the
+// order they appear. Operands refer to them by index or by their optional
+// name, if it is unique. Integers are decimal, floats are anything
+// strconv.ParseFloat accepts and strings are double quoted Go strings.
+//
+//	.const [NAME] int VALUE
+//	.const [NAME] float VALUE
+//	.const [NAME] string "VALUE"
+//
+// Compiled functions are declared with .func and end with .end. Their
+// bodies are listings themselves and may declare constants and functions
+// too. Like in the compiler's output, a function is added to the constant
+// pool once its body is complete, after the functions declared inside of
+// it. NAME is also the name used in stack traces, locals defaults to the
+// number of parameters.
+//
+//	.func [NAME] [params=N] [locals=N]
+//	    OpGetLocal 0
+//	    OpReturnValue
+//	.end
+//
+// A line of the form "NAME:" defines a label for the offset of the next
+// instruction, jumps can use it as their operand. Labels are local to the
+// function body they are defined in.
+//
+//	    OpTrue
+//	    OpJumpNotTruthy else
+//	    OpConstant 0
+//	else:
+//	    OpPop
+//
+// Exception handlers of a function body or the main program are declared
+// with .catch and .finally, anywhere in the body. They protect the
+// instructions from START up to END, which are labels or offsets like jump
+// targets, and continue at TARGET with DEPTH values kept on the stack. The
+// handlers are looked up in the order they are declared.
+//
+//	.catch START END TARGET DEPTH
+//	.finally START END TARGET DEPTH
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/object"
+	"strconv"
+	"strings"
+)
+
+// Error is a problem in a listing
+type Error struct {
+	Line    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// Assemble converts a listing to bytecode
+func Assemble(src string) (*compiler.Bytecode, error) {
+	a := &assembler{names: map[string]int{}}
+	if err := a.parse(src); err != nil {
+		return nil, err
+	}
+
+	if err := a.main.assemble(a.names); err != nil {
+		return nil, err
+	}
+
+	for _, c := range a.constants {
+		fn, ok := c.(*function)
+		if !ok {
+			continue
+		}
+		if err := fn.assemble(a.names); err != nil {
+			return nil, err
+		}
+	}
+
+	bytecode := &compiler.Bytecode{
+		Instructions: a.main.compiled.Instructions,
+		Constants:    make([]object.Object, len(a.constants)),
+		Handlers:     a.main.compiled.Handlers,
+	}
+	for i, c := range a.constants {
+		if fn, ok := c.(*function); ok {
+			bytecode.Constants[i] = fn.compiled
+		} else {
+			bytecode.Constants[i] = c.(object.Object)
+		}
+	}
+	return bytecode, nil
+}
+
+// ambiguous marks names shared by several constants
+const ambiguous = -1
+
+type assembler struct {
+	main      *function
+	functions []*function    // the .func blocks we're in, innermost last
+	constants []interface{}  // object.Object or *function, in pool order
+	names     map[string]int // named constants, by index
+}
+
+// function is the body of a .func block or the main program
+type function struct {
+	compiled     *object.CompiledFunction
+	instructions []instruction
+	handlers     []handler
+	labels       map[string]int // labels, by index of the next instruction
+	line         int            // where the .func directive is
+}
+
+// handler is a .catch or .finally directive
+type handler struct {
+	finally  bool
+	operands []string // start, end, target and depth
+	line     int
+}
+
+type instruction struct {
+	op       code.Opcode
+	def      *code.Definition
+	operands []string
+	line     int
+}
+
+func newFunction(line int) *function {
+	return &function{
+		compiled: &object.CompiledFunction{},
+		labels:   map[string]int{},
+		line:     line,
+	}
+}
+
+func (a *assembler) parse(src string) error {
+	a.main = newFunction(0)
+
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		fields, err := splitFields(scanner.Text())
+		if err != nil {
+			return &Error{Line: line, Message: err.Error()}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		if err := a.parseLine(fields, line); err != nil {
+			return &Error{Line: line, Message: err.Error()}
+		}
+	}
+
+	if n := len(a.functions); n > 0 {
+		return &Error{Line: a.functions[n-1].line, Message: ".func without .end"}
+	}
+	return nil
+}
+
+func (a *assembler) current() *function {
+	if n := len(a.functions); n > 0 {
+		return a.functions[n-1]
+	}
+	return a.main
+}
+
+func (a *assembler) parseLine(fields []string, line int) error {
+	first := fields[0]
+
+	switch {
+	case first == ".const":
+		return a.parseConst(fields[1:])
+
+	case first == ".func":
+		return a.parseFunc(fields[1:], line)
+
+	case first == ".end":
+		if len(fields) != 1 {
+			return fmt.Errorf("unexpected %q after .end", fields[1])
+		}
+		n := len(a.functions)
+		if n == 0 {
+			return fmt.Errorf(".end without .func")
+		}
+		fn := a.functions[n-1]
+		a.functions = a.functions[:n-1]
+		return a.addConstant(fn.compiled.Name, fn)
+
+	case first == ".catch" || first == ".finally":
+		if len(fields) != 5 {
+			return fmt.Errorf("usage: %s START END TARGET DEPTH", first)
+		}
+		fn := a.current()
+		fn.handlers = append(fn.handlers, handler{
+			finally:  first == ".finally",
+			operands: fields[1:],
+			line:     line,
+		})
+		return nil
+
+	case strings.HasPrefix(first, "."):
+		return fmt.Errorf("unknown directive %s", first)
+
+	case strings.HasSuffix(first, ":"):
+		if len(fields) != 1 {
+			return fmt.Errorf("unexpected %q after label", fields[1])
+		}
+		name := strings.TrimSuffix(first, ":")
+		if !isName(name) {
+			return fmt.Errorf("invalid label %q", name)
+		}
+		fn := a.current()
+		if _, ok := fn.labels[name]; ok {
+			return fmt.Errorf("label %s already defined", name)
+		}
+		fn.labels[name] = len(fn.instructions)
+		return nil
+	}
+
+	// skip the offset in front of the instruction
+	if _, err := strconv.Atoi(first); err == nil {
+		fields = fields[1:]
+		if len(fields) == 0 {
+			return fmt.Errorf("missing instruction after offset")
+		}
+	}
+
+	op, def, ok := code.LookupName(fields[0])
+	if !ok {
+		return fmt.Errorf("unknown opcode %s", fields[0])
+	}
+	operands := fields[1:]
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Errorf("%s takes %d operands, got %d",
+			def.Name, len(def.OperandWidths), len(operands))
+	}
+
+	fn := a.current()
+	fn.instructions = append(fn.instructions, instruction{
+		op:       op,
+		def:      def,
+		operands: operands,
+		line:     line,
+	})
+	return nil
+}
+
+// parseConst parses `.const [NAME] TYPE VALUE`
+func (a *assembler) parseConst(fields []string) error {
+	name := ""
+	if len(fields) == 3 {
+		name, fields = fields[0], fields[1:]
+	}
+	if len(fields) != 2 {
+		return fmt.Errorf("usage: .const [NAME] int|float|string VALUE")
+	}
+
+	var obj object.Object
+	switch fields[0] {
+	case "int":
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %s", fields[1])
+		}
+		obj = &object.Integer{Value: value}
+	case "float":
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return fmt.Errorf("invalid float %s", fields[1])
+		}
+		obj = &object.Float{Value: value}
+	case "string":
+		value, err := strconv.Unquote(fields[1])
+		if err != nil || !strings.HasPrefix(fields[1], `"`) {
+			return fmt.Errorf("invalid string %s", fields[1])
+		}
+		obj = &object.String{Value: value}
+	default:
+		return fmt.Errorf("unknown constant type %s", fields[0])
+	}
+
+	return a.addConstant(name, obj)
+}
+
+// parseFunc parses `.func [NAME] [params=N] [locals=N]`
+func (a *assembler) parseFunc(fields []string, line int) error {
+	fn := newFunction(line)
+
+	if len(fields) > 0 && !strings.Contains(fields[0], "=") {
+		fn.compiled.Name, fields = fields[0], fields[1:]
+	}
+
+	locals := -1
+	for _, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid .func attribute %q", field)
+		}
+		value, err := strconv.Atoi(parts[1])
+		if err != nil || value < 0 {
+			return fmt.Errorf("invalid value for %s: %s", parts[0], parts[1])
+		}
+
+		switch parts[0] {
+		case "params":
+			fn.compiled.NumParameters = value
+		case "locals":
+			locals = value
+		default:
+			return fmt.Errorf("unknown .func attribute %s", parts[0])
+		}
+	}
+
+	fn.compiled.NumLocals = locals
+	if locals < 0 {
+		fn.compiled.NumLocals = fn.compiled.NumParameters
+	}
+
+	a.functions = append(a.functions, fn)
+	return nil
+}
+
+func (a *assembler) addConstant(name string, c interface{}) error {
+	if name != "" {
+		if !isName(name) {
+			return fmt.Errorf("invalid constant name %q", name)
+		}
+		if _, ok := a.names[name]; ok {
+			// functions of different scopes may share a name, only
+			// referring to it is an error
+			a.names[name] = ambiguous
+		} else {
+			a.names[name] = len(a.constants)
+		}
+	}
+	a.constants = append(a.constants, c)
+	return nil
+}
+
+// assemble encodes the instructions and handlers of the compiled function,
+// resolving labels and constant names
+func (fn *function) assemble(constants map[string]int) error {
+	offsets := make([]int, len(fn.instructions)+1)
+	for i, ins := range fn.instructions {
+		size := 1
+		for _, w := range ins.def.OperandWidths {
+			size += w
+		}
+		offsets[i+1] = offsets[i] + size
+	}
+
+	out := code.Instructions{}
+	for _, ins := range fn.instructions {
+		operands := make([]int, len(ins.operands))
+		for i, operand := range ins.operands {
+			value, err := fn.resolve(operand, offsets, constants)
+			if err != nil {
+				return &Error{Line: ins.line, Message: err.Error()}
+			}
+
+			width := ins.def.OperandWidths[i]
+			if value < 0 || value >= 1<<(8*uint(width)) {
+				return &Error{
+					Line: ins.line,
+					Message: fmt.Sprintf("operand %d of %s doesn't fit into %d bytes",
+						value, ins.def.Name, width),
+				}
+			}
+			operands[i] = value
+		}
+
+		out = append(out, code.Make(ins.op, operands...)...)
+	}
+	fn.compiled.Instructions = out
+
+	for _, h := range fn.handlers {
+		values := make([]int, len(h.operands))
+		for i, operand := range h.operands {
+			value, err := fn.resolve(operand, offsets, constants)
+			if err != nil {
+				return &Error{Line: h.line, Message: err.Error()}
+			}
+			if value < 0 {
+				return &Error{Line: h.line, Message: fmt.Sprintf("negative handler operand %d", value)}
+			}
+			values[i] = value
+		}
+
+		fn.compiled.Handlers = append(fn.compiled.Handlers, code.Handler{
+			Start:   values[0],
+			End:     values[1],
+			Target:  values[2],
+			Depth:   values[3],
+			Finally: h.finally,
+		})
+	}
+
+	return nil
+}
+
+func (fn *function) resolve(operand string, offsets []int, constants map[string]int) (int, error) {
+	if value, err := strconv.Atoi(operand); err == nil {
+		return value, nil
+	}
+
+	if i, ok := fn.labels[operand]; ok {
+		return offsets[i], nil
+	}
+	if i, ok := constants[operand]; ok {
+		if i == ambiguous {
+			return 0, fmt.Errorf("more than one constant is named %s", operand)
+		}
+		return i, nil
+	}
+	return 0, fmt.Errorf("undefined label or constant %s", operand)
+}
+
+// splitFields splits a line into whitespace separated fields, keeping
+// quoted strings together and dropping comments
+func splitFields(line string) ([]string, error) {
+	fields := []string{}
+
+	for i := 0; i < len(line); {
+		switch ch := line[i]; {
+		case ch == ';':
+			return fields, nil
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			i++
+		case ch == '"':
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			fields = append(fields, line[i:end+1])
+			i = end + 1
+		default:
+			end := i
+			for end < len(line) && !strings.ContainsRune(" \t\r;\"", rune(line[end])) {
+				end++
+			}
+			fields = append(fields, line[i:end])
+			i = end
+		}
+	}
+
+	return fields, nil
+}
+
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, ch := range s {
+		letter := ch == '_' || 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z'
+		digit := '0' <= ch && ch <= '9'
+		if !letter && !(digit && i > 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package asm
+
+import (
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"reflect"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	input := `
+	; let max = fn(a, b) { if (a > b) { a } else { b } }; max(3, 7)
+	.func max params=2
+		0000 OpGetLocal 0
+		0002 OpGetLocal 1
+		0004 OpGreaterThan
+		0005 OpJumpNotTruthy else
+		0008 OpGetLocal 0
+		0010 OpJump end
+	else:
+		0013 OpGetLocal 1
+	end:
+		0015 OpReturnValue
+	.end
+	.const three int 3
+	.const int 7
+
+	OpClosure max 0
+	OpSetGlobal 0
+	OpGetGlobal 0
+	OpConstant three
+	OpConstant 2 ; seven
+	OpCall 2
+	OpPop
+	`
+
+	bytecode, err := Assemble(input)
+	if err != nil {
+		t.Fatalf("assembler error: %s", err)
+	}
+
+	comp := compiler.New()
+	program := parser.New(lexer.New(
+		"let max = fn(a, b) { if (a > b) { a } else { b } }; max(3, 7)")).ParseProgram()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	expected := comp.ByteCode().StripDebugInfo()
+
+	if bytecode.Instructions.String() != expected.Instructions.String() {
+		t.Errorf("wrong instructions.\nwant=%s\ngot=%s",
+			expected.Instructions, bytecode.Instructions)
+	}
+
+	if len(bytecode.Constants) != len(expected.Constants) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d",
+			len(expected.Constants), len(bytecode.Constants))
+	}
+	for i, c := range expected.Constants {
+		if !reflect.DeepEqual(bytecode.Constants[i], c) {
+			t.Errorf("constant %d differs. want=%+v, got=%+v", i, c, bytecode.Constants[i])
+		}
+	}
+
+	machine := vm.New(bytecode)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if result := machine.LastPoppedStackElem(); result.Inspect() != "7" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+}
+
+func TestAssembleNestedFunctions(t *testing.T) {
+	input := `
+	.func outer params=1
+		.func inner params=1
+			OpGetFree 0
+			OpGetLocal 0
+			OpAdd
+			OpReturnValue
+		.end
+		OpGetLocal 0
+		OpClosure inner 1
+		OpReturnValue
+	.end
+	.const string "mon"
+	.const string "key"
+	OpClosure outer 0
+	OpConstant 2
+	OpCall 1
+	OpConstant 3
+	OpCall 1
+	OpPop
+	`
+
+	bytecode, err := Assemble(input)
+	if err != nil {
+		t.Fatalf("assembler error: %s", err)
+	}
+
+	inner, ok := bytecode.Constants[0].(*object.CompiledFunction)
+	if !ok || inner.Name != "inner" {
+		t.Fatalf("constant 0 is not inner. got=%+v", bytecode.Constants[0])
+	}
+	expected := code.Make(code.OpClosure, 0, 1)
+	outer := bytecode.Constants[1].(*object.CompiledFunction)
+	if string(outer.Instructions[2:6]) != string(expected) {
+		t.Errorf("wrong closure instruction. want=%v, got=%v",
+			expected, outer.Instructions[2:6])
+	}
+
+	machine := vm.New(bytecode)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if result := machine.LastPoppedStackElem(); result.Inspect() != "monkey" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"OpFoo", "line 1: unknown opcode OpFoo"},
+		{"OpConstant", "line 1: OpConstant takes 1 operands, got 0"},
+		{"\nOpJump nowhere", "line 2: undefined label or constant nowhere"},
+		{"OpGetLocal 256", "line 1: operand 256 of OpGetLocal doesn't fit into 1 bytes"},
+		{".const int x", "line 1: invalid integer x"},
+		{`.const string "open`, "line 1: unterminated string"},
+		{".func f\nOpNull", "line 1: .func without .end"},
+		{".end", "line 1: .end without .func"},
+		{"a:\na:", "line 2: label a already defined"},
+		{".func f\n.end\n.func f\n.end\nOpClosure f 0",
+			"line 5: more than one constant is named f"},
+		{".bogus", "line 1: unknown directive .bogus"},
+		{".catch start end", "line 1: usage: .catch START END TARGET DEPTH"},
+		{"\n.finally 0 1 there 0\nOpNull", "line 2: undefined label or constant there"},
+	}
+
+	for _, tt := range tests {
+		_, err := Assemble(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("input %q: wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
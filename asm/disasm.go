@@ -0,0 +1,234 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/object"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Disassemble converts bytecode to a listing, which Assemble turns back
+// into the same bytecode without debug info. The constant pool is listed
+// first, compiled functions with their instructions, followed by the main
+// program. Jump targets and the ranges and targets of exception handlers
+// are replaced by labels, operands referring to
+// constants, globals, builtins and free variables are annotated with what
+// they refer to. The names of globals and builtins are taken from symbols,
+// which may be nil.
+func Disassemble(bytecode *compiler.Bytecode, symbols *compiler.SymbolTable) string {
+	d := &disassembler{
+		constants: bytecode.Constants,
+		globals:   map[int]string{},
+		builtins:  map[int]string{},
+	}
+
+	if symbols != nil {
+		for _, s := range symbols.Symbols() {
+			switch s.Scope {
+			case compiler.GlobalScope:
+				d.globals[s.Index] = s.Name
+			case compiler.BuiltinScope:
+				d.builtins[s.Index] = s.Name
+			}
+		}
+	}
+
+	if len(bytecode.Constants) > 0 {
+		d.out.WriteString("; constants\n")
+		for i, c := range bytecode.Constants {
+			d.constant(i, c)
+		}
+		d.out.WriteString("\n")
+	}
+
+	d.out.WriteString("; main program\n")
+	d.instructions(bytecode.Instructions, bytecode.Handlers, nil, "")
+
+	return d.out.String()
+}
+
+type disassembler struct {
+	out       bytes.Buffer
+	constants []object.Object
+	globals   map[int]string // names of globals, by index
+	builtins  map[int]string // names of builtins, by index
+}
+
+func (d *disassembler) constant(i int, obj object.Object) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		fmt.Fprintf(&d.out, ".const int %d ; %d\n", obj.Value, i)
+
+	case *object.Float:
+		fmt.Fprintf(&d.out, ".const float %s ; %d\n",
+			strconv.FormatFloat(obj.Value, 'g', -1, 64), i)
+
+	case *object.String:
+		fmt.Fprintf(&d.out, ".const string %s ; %d\n", strconv.Quote(obj.Value), i)
+
+	case *object.CompiledFunction:
+		d.out.WriteString(".func")
+		if obj.Name != "" {
+			d.out.WriteString(" " + obj.Name)
+		}
+		fmt.Fprintf(&d.out, " params=%d locals=%d ; %d\n",
+			obj.NumParameters, obj.NumLocals, i)
+		d.instructions(obj.Instructions, obj.Handlers, obj.FreeNames, "\t")
+		d.out.WriteString(".end\n")
+
+	default:
+		// there is no syntax for other constants, assembling the listing
+		// reports the line instead of shifting the following constants
+		fmt.Fprintf(&d.out, ".const %s %s ; %d\n",
+			strings.ToLower(string(obj.Type())), strconv.Quote(obj.Inspect()), i)
+	}
+}
+
+// decoded is an instruction read from the bytecode
+type decoded struct {
+	offset   int
+	op       code.Opcode
+	def      *code.Definition
+	operands []int
+}
+
+func (d *disassembler) instructions(
+	ins code.Instructions,
+	handlers code.Handlers,
+	free []string,
+	indent string,
+) {
+	decoded, err := decode(ins)
+
+	// name the jump targets in the order they appear
+	labels := map[int]string{}
+	for _, in := range decoded {
+		if isJump(in.op) {
+			labels[in.operands[0]] = ""
+		}
+	}
+	for _, h := range handlers {
+		labels[h.Start], labels[h.End], labels[h.Target] = "", "", ""
+	}
+	offsets := make([]int, 0, len(labels))
+	for offset := range labels {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+	for i, offset := range offsets {
+		labels[offset] = fmt.Sprintf("L%d", i+1)
+	}
+
+	for _, h := range handlers {
+		directive := ".catch"
+		if h.Finally {
+			directive = ".finally"
+		}
+		fmt.Fprintf(&d.out, "%s%s %s %s %s %d\n", indent, directive,
+			labels[h.Start], labels[h.End], labels[h.Target], h.Depth)
+	}
+
+	for _, in := range decoded {
+		if label, ok := labels[in.offset]; ok {
+			fmt.Fprintf(&d.out, "%s:\n", label)
+		}
+
+		fmt.Fprintf(&d.out, "%s%04d %s", indent, in.offset, in.def.Name)
+		for i, operand := range in.operands {
+			if isJump(in.op) && i == 0 {
+				fmt.Fprintf(&d.out, " %s", labels[operand])
+			} else {
+				fmt.Fprintf(&d.out, " %d", operand)
+			}
+		}
+		if comment := d.comment(in, free); comment != "" {
+			fmt.Fprintf(&d.out, " ; %s", comment)
+		}
+		d.out.WriteString("\n")
+	}
+
+	// labels for jumps past the last instruction
+	for _, offset := range offsets {
+		if offset >= len(ins) {
+			fmt.Fprintf(&d.out, "%s:\n", labels[offset])
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(&d.out, "%s; ERROR: %s\n", indent, err)
+	}
+}
+
+// decode reads instructions up to the end or the first invalid one
+func decode(ins code.Instructions) ([]decoded, error) {
+	result := []decoded{}
+
+	for offset := 0; offset < len(ins); {
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			return result, fmt.Errorf("offset %d: %s", offset, err)
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if offset+1+width > len(ins) {
+			return result, fmt.Errorf("offset %d: truncated %s", offset, def.Name)
+		}
+
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+		result = append(result, decoded{
+			offset:   offset,
+			op:       code.Opcode(ins[offset]),
+			def:      def,
+			operands: operands,
+		})
+		offset += 1 + read
+	}
+
+	return result, nil
+}
+
+// comment describes what the operands of an instruction refer to
+func (d *disassembler) comment(in decoded, free []string) string {
+	switch in.op {
+	case code.OpConstant, code.OpClosure:
+		return d.describeConstant(in.operands[0])
+	case code.OpGetGlobal, code.OpSetGlobal:
+		return d.globals[in.operands[0]]
+	case code.OpGetBuiltin:
+		return d.builtins[in.operands[0]]
+	case code.OpGetFree, code.OpSetFree, code.OpGetFreeCell:
+		if i := in.operands[0]; i < len(free) {
+			return free[i]
+		}
+	}
+	return ""
+}
+
+func (d *disassembler) describeConstant(i int) string {
+	if i >= len(d.constants) {
+		return "invalid constant"
+	}
+
+	switch c := d.constants[i].(type) {
+	case *object.String:
+		return strconv.Quote(c.Value)
+	case *object.CompiledFunction:
+		if c.Name == "" {
+			return "fn"
+		}
+		return "fn " + c.Name
+	default:
+		return c.Inspect()
+	}
+}
+
+func isJump(op code.Opcode) bool {
+	return op == code.OpJump || op == code.OpJumpNotTruthy || op == code.OpIterNext
+}
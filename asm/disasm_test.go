@@ -0,0 +1,117 @@
+package asm
+
+import (
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	tests := []string{
+		`1 + 2; "monkey"; 0.1 * 3.5`,
+		`let max = fn(a, b) { if (a > b) { a } else { b } }; max(3, 7)`,
+		`if (true) { 10 }; 3333;`,
+		`let adder = fn(x) { fn(y) { fn(z) { x + y + z } } }; adder(1)(2)(3)`,
+		`let countdown = fn(n) { if (n == 0) { 0 } else { countdown(n - 1) } };`,
+		`let a = [1, "two", {3: "four; five"}]; len(a); puts(a[2][3])`,
+		`try { throw 1 } catch (e) { e } finally { puts("done") }`,
+		`let f = fn(x) { try { x / 0 } finally { x } }; try { f(1) } catch (e) { 0 }`,
+		`let n = 2; "${n} monkeys, ${[n]}"`,
+	}
+
+	for _, input := range tests {
+		bytecode, _ := compile(t, input)
+
+		listing := Disassemble(bytecode, nil)
+		assembled, err := Assemble(listing)
+		if err != nil {
+			t.Fatalf("assembler error for %q: %s\n%s", input, err, listing)
+		}
+
+		expected := bytecode.StripDebugInfo()
+		expected.SourceMap = nil
+		if !reflect.DeepEqual(assembled, expected) {
+			t.Errorf("bytecode for %q differs after round trip.\nlisting:\n%s",
+				input, listing)
+		}
+	}
+}
+
+func TestDisassembleAnnotations(t *testing.T) {
+	input := `
+	let greeting = "hello";
+	let greeter = fn(name) {
+		let greet = fn() { puts(greeting, name); greet };
+		greet
+	};
+	if (len(greeting) > 3) { greeter("world") }
+	`
+	bytecode, symbols := compile(t, input)
+
+	listing := Disassemble(bytecode, symbols)
+
+	expected := []string{
+		`.const string "hello" ; 0`,
+		".func greet params=0 locals=0 ; 1",
+		"\t0000 OpGetBuiltin 1 ; puts",
+		"\t0003 OpGetGlobal 0 ; greeting",
+		"\t0006 OpGetFree 0 ; name",
+		"\t0011 OpCurrentClosure",
+		".func greeter params=1 locals=2 ; 2",
+		"\t0002 OpClosure 1 1 ; fn greet",
+		"0000 OpConstant 0 ; \"hello\"",
+		"0003 OpSetGlobal 0 ; greeting",
+		"0025 OpJumpNotTruthy L1",
+		"0036 OpJump L2\nL1:\n0039 OpNull\nL2:\n0040 OpPop",
+	}
+
+	for _, want := range expected {
+		if !strings.Contains(listing, want) {
+			t.Errorf("listing does not contain %q.\nlisting:\n%s", want, listing)
+		}
+	}
+}
+
+func TestDisassembleInvalidInstructions(t *testing.T) {
+	bytecode := &compiler.Bytecode{
+		Instructions: append(code.Make(code.OpTrue), 255),
+		Constants:    []object.Object{},
+	}
+
+	listing := Disassemble(bytecode, nil)
+
+	expected := "; main program\n0000 OpTrue\n; ERROR: offset 1: opcode 255 undefined\n"
+	if listing != expected {
+		t.Errorf("wrong listing.\nwant=%q\ngot=%q", expected, listing)
+	}
+
+	bytecode.Instructions = code.Make(code.OpConstant, 1)[:2]
+	listing = Disassemble(bytecode, nil)
+
+	expected = "; main program\n; ERROR: offset 0: truncated OpConstant\n"
+	if listing != expected {
+		t.Errorf("wrong listing.\nwant=%q\ngot=%q", expected, listing)
+	}
+}
+
+func compile(t *testing.T, input string) (*compiler.Bytecode, *compiler.SymbolTable) {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	symbols := compiler.NewSymbolTableWithBuiltins(object.Builtins)
+	comp := compiler.NewWithState(symbols, []object.Object{})
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error for %q: %s", input, err)
+	}
+	return comp.ByteCode(), symbols
+}
@@ -1,16 +1,11 @@
 package main
 
 import (
-    "flag"
-    "fmt"
-    "time"
+	"flag"
+	"fmt"
+	"time"
 
-    "monkey/compiler"
-    "monkey/evaluator"
-    "monkey/lexer"
-    "monkey/object"
-    "monkey/parser"
-    "monkey/vm"
+	"monkey"
 )
 
 var engine = flag.String("engine", "vm", "use 'vm' or 'eval'")
@@ -31,45 +26,31 @@ fibonacci(35);
 `
 
 func main() {
-    flag.Parse()
-
-    var duration time.Duration
-    var result object.Object
-
-    l := lexer.New(input)
-    p := parser.New(l)
-    program := p.ParseProgram()
-
-    if *engine == "vm" {
-        comp := compiler.New()
-        err := comp.Compile(program)
-        if err != nil {
-            fmt.Printf("compiler error: %s", err)
-            return
-        }
-
-        machine := vm.New(comp.ByteCode())
-
-        start := time.Now()
-
-        err = machine.Run()
-        if err != nil {
-            fmt.Printf("vm error: %s", err)
-            return
-        }
-
-        duration = time.Since(start)
-        result = machine.LastPoppedStackElem()
-    } else {
-        env := object.NewEnvironment()
-        start := time.Now()
-        result = evaluator.Eval(program, env)
-        duration = time.Since(start)
-    }
-
-    fmt.Printf(
-        "engine=%s, result=%s, duration=%s\n",
-        *engine,
-        result.Inspect(),
-        duration)
-}
\ No newline at end of file
+	flag.Parse()
+
+	if *engine != string(monkey.EngineVM) && *engine != string(monkey.EngineEval) {
+		fmt.Printf("unknown engine %q, use 'vm' or 'eval'\n", *engine)
+		return
+	}
+
+	interp := monkey.New(monkey.Engine(*engine))
+	program, err := interp.Compile(input)
+	if err != nil {
+		fmt.Printf("compile error: %s\n", err)
+		return
+	}
+
+	start := time.Now()
+	result, err := interp.Run(program)
+	if err != nil {
+		fmt.Printf("runtime error: %s\n", err)
+		return
+	}
+	duration := time.Since(start)
+
+	fmt.Printf(
+		"engine=%s, result=%s, duration=%s\n",
+		*engine,
+		result.Inspect(),
+		duration)
+}
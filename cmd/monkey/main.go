@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"monkey"
+	"monkey/asm"
+	"monkey/compiler"
+	"monkey/object"
+	"monkey/repl"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const MONKEY_FACE = `            __,__
+   .--.  .-"     "-.  .--.
+  / .. \/  .-. .-.  \/ .. \
+ | |  '|  /   Y   \  |'  | |
+ | \   \  \ 0 | 0 /  /   / |
+  \ '- ,\.-"""""""-./, -' /
+   ''-' /_   ^ ^   _\ '-''
+       |  \._   _./  |
+       \   \ '~' /   /
+        '._ '-=-' _.'
+           '-----'
+`
+
+const usage = `Usage:
+  monkey                                  start the REPL
+  monkey repl                             start the REPL
+  monkey run [--engine=vm|eval] [-O] FILE [ARGS...]
+                                          run a Monkey script or a bytecode
+                                          file built with monkey build
+  monkey build [-O] [--strip] [-o OUT] FILE
+                                          compile a script to a bytecode file,
+                                          OUT defaults to FILE with .mkc
+  monkey disasm [-O] FILE                 print the bytecode of a script or a
+                                          bytecode file as an assembly listing
+  monkey eval [--engine=vm|eval] [-O] -e CODE [ARGS...]
+                                          evaluate CODE and print the result
+
+Script arguments are available to the program as the array ` + "`args`" + `.
+-O compiles scripts with optimizations, like folding constant expressions.
+`
+
+// Exit codes of the monkey command
+const (
+	exitOK      = 0
+	exitRuntime = 1 // the program failed while running
+	exitUsage   = 2 // the command line was invalid
+	exitParse   = 3 // the program has syntax errors
+	exitCompile = 4 // the program could not be compiled to bytecode
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(argv []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(argv) == 0 || argv[0] == "repl" {
+		fmt.Fprintf(stdout, "Hello! This is the Monkey Programming Language!\n %s", MONKEY_FACE)
+		fmt.Fprintf(stdout, "This is the REPL. Type in some Monkey commands!\n")
+		repl.Start(stdin, stdout)
+		return exitOK
+	}
+
+	switch argv[0] {
+	case "run":
+		return runCommand(argv[1:], stdout, stderr)
+	case "eval":
+		return evalCommand(argv[1:], stdout, stderr)
+	case "build":
+		return buildCommand(argv[1:], stderr)
+	case "disasm":
+		return disasmCommand(argv[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "monkey: unknown command %q\n\n%s", argv[0], usage)
+		return exitUsage
+	}
+}
+
+// runCommand implements `monkey run FILE [ARGS...]`
+func runCommand(argv []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("run", stderr)
+	engine := flags.String("engine", "vm", "use 'vm' or 'eval'")
+	optimize := flags.Bool("O", false, "compile with optimizations")
+	if err := flags.Parse(argv); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() < 1 {
+		fmt.Fprintf(stderr, "monkey run: missing script file\n\n%s", usage)
+		return exitUsage
+	}
+
+	filename := flags.Arg(0)
+	source, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey run: %s\n", err)
+		return exitUsage
+	}
+
+	if compiler.IsBytecode(source) {
+		return executeBytecode(source, flags.Args()[1:], stdout, stderr)
+	}
+
+	_, status := execute(*engine, *optimize, string(source), filename,
+		flags.Args()[1:], stdout, stderr)
+	return status
+}
+
+// buildCommand implements `monkey build FILE`
+func buildCommand(argv []string, stderr io.Writer) int {
+	flags := newFlagSet("build", stderr)
+	output := flags.String("o", "", "write the bytecode to this file")
+	strip := flags.Bool("strip", false, "leave out debug info")
+	optimize := flags.Bool("O", false, "compile with optimizations")
+	if err := flags.Parse(argv); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintf(stderr, "monkey build: need exactly one script file\n\n%s", usage)
+		return exitUsage
+	}
+
+	filename := flags.Arg(0)
+	source, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey build: %s\n", err)
+		return exitUsage
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".mkc"
+	}
+
+	interp := newInterpreter(monkey.EngineVM, nil, ioutil.Discard, stderr)
+	interp.Optimize = *optimize
+	program, status := compile(interp, string(source), filename, stderr)
+	if status != exitOK {
+		return status
+	}
+
+	bytecode := program.Bytecode()
+	if *strip {
+		bytecode = bytecode.StripDebugInfo()
+	}
+
+	var buf bytes.Buffer
+	if err := bytecode.Encode(&buf); err != nil {
+		fmt.Fprintf(stderr, "monkey build: %s\n", err)
+		return exitCompile
+	}
+	if err := ioutil.WriteFile(*output, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintf(stderr, "monkey build: %s\n", err)
+		return exitUsage
+	}
+
+	return exitOK
+}
+
+// disasmCommand implements `monkey disasm FILE`
+func disasmCommand(argv []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("disasm", stderr)
+	optimize := flags.Bool("O", false, "compile with optimizations")
+	if err := flags.Parse(argv); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintf(stderr, "monkey disasm: need exactly one file\n\n%s", usage)
+		return exitUsage
+	}
+
+	filename := flags.Arg(0)
+	source, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey disasm: %s\n", err)
+		return exitUsage
+	}
+
+	// the symbols of bytecode files are lost, except for the ones every
+	// interpreter defines
+	interp := newInterpreter(monkey.EngineVM, nil, ioutil.Discard, stderr)
+	interp.Optimize = *optimize
+
+	var bytecode *compiler.Bytecode
+	if compiler.IsBytecode(source) {
+		bytecode, err = compiler.Decode(bytes.NewReader(source))
+		if err != nil {
+			fmt.Fprintf(stderr, "monkey disasm: %s\n", err)
+			return exitCompile
+		}
+	} else {
+		program, status := compile(interp, string(source), filename, stderr)
+		if status != exitOK {
+			return status
+		}
+		bytecode = program.Bytecode()
+	}
+
+	fmt.Fprint(stdout, asm.Disassemble(bytecode, interp.SymbolTable()))
+	return exitOK
+}
+
+// executeBytecode runs a file built with monkey build on the VM
+func executeBytecode(data []byte, args []string, stdout, stderr io.Writer) int {
+	bytecode, err := compiler.Decode(bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey run: %s\n", err)
+		return exitCompile
+	}
+
+	interp := newInterpreter(monkey.EngineVM, args, stdout, stderr)
+	program, err := interp.LoadBytecode(bytecode)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey run: %s\n", err)
+		return exitCompile
+	}
+
+	_, status := runProgram(interp, program, stderr)
+	return status
+}
+
+// evalCommand implements `monkey eval -e CODE [ARGS...]`. Unlike run it
+// prints the value the program evaluates to.
+func evalCommand(argv []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("eval", stderr)
+	engine := flags.String("engine", "vm", "use 'vm' or 'eval'")
+	optimize := flags.Bool("O", false, "compile with optimizations")
+	source := flags.String("e", "", "Monkey code to evaluate")
+	if err := flags.Parse(argv); err != nil {
+		return exitUsage
+	}
+	if *source == "" {
+		fmt.Fprintf(stderr, "monkey eval: missing -e CODE\n\n%s", usage)
+		return exitUsage
+	}
+
+	result, status := execute(*engine, *optimize, *source, "-e", flags.Args(),
+		stdout, stderr)
+	if status == exitOK && result != nil && result.Type() != object.NULL_OBJ {
+		fmt.Fprintln(stdout, result.Inspect())
+	}
+	return status
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	return flags
+}
+
+// execute compiles source, with optimizations if optimize is set, and runs
+// it with the chosen engine. It returns the value the program evaluated to
+// and the exit status of the command.
+func execute(
+	engine string,
+	optimize bool,
+	source, filename string,
+	args []string,
+	stdout, stderr io.Writer,
+) (object.Object, int) {
+	if engine != string(monkey.EngineVM) && engine != string(monkey.EngineEval) {
+		fmt.Fprintf(stderr, "monkey: unknown engine %q, use 'vm' or 'eval'\n", engine)
+		return nil, exitUsage
+	}
+
+	interp := newInterpreter(monkey.Engine(engine), args, stdout, stderr)
+	interp.Optimize = optimize
+	program, status := compile(interp, source, filename, stderr)
+	if status != exitOK {
+		return nil, status
+	}
+
+	return runProgram(interp, program, stderr)
+}
+
+// newInterpreter creates an interpreter with the script arguments defined.
+// Bytecode files rely on the globals being defined in the same order when
+// they are built and run.
+func newInterpreter(
+	engine monkey.Engine,
+	args []string,
+	stdout, stderr io.Writer,
+) *monkey.Interpreter {
+	interp := monkey.New(engine)
+	interp.Stdout = stdout
+	interp.Stderr = stderr
+
+	scriptArgs := &object.Array{Elements: []object.Object{}}
+	for _, arg := range args {
+		scriptArgs.Elements = append(scriptArgs.Elements, &object.String{Value: arg})
+	}
+	interp.Set("args", scriptArgs)
+
+	return interp
+}
+
+// compile compiles source, reporting errors to stderr
+func compile(
+	interp *monkey.Interpreter,
+	source, filename string,
+	stderr io.Writer,
+) (*monkey.Program, int) {
+	program, err := interp.CompileFile(filename, source)
+	switch err := err.(type) {
+	case nil:
+		return program, exitOK
+	case *monkey.SyntaxError:
+		err.Render(stderr)
+		return nil, exitParse
+	default:
+		fmt.Fprintln(stderr, err)
+		return nil, exitCompile
+	}
+}
+
+// runProgram runs a compiled program, reporting errors to stderr
+func runProgram(
+	interp *monkey.Interpreter,
+	program *monkey.Program,
+	stderr io.Writer,
+) (object.Object, int) {
+	result, err := interp.Run(program)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		if runtimeErr, ok := err.(*monkey.RuntimeError); ok && len(runtimeErr.Stack) > 1 {
+			fmt.Fprintf(stderr, "stack trace:\n%s", runtimeErr.Stack)
+		}
+		return nil, exitRuntime
+	}
+
+	return result, exitOK
+}
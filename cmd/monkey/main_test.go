@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"monkey/code"
+	"monkey/compiler"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	hello := writeFile(t, dir, "hello.mk", `puts("hello " + args[0]); 1 + 2`)
+	syntax := writeFile(t, dir, "syntax.mk", "let x = ;")
+	failing := writeFile(t, dir, "failing.mk", "let f = fn(x) { x / 0 }; f(1)")
+	compileErr := writeFile(t, dir, "compile.mk", "len = 1;")
+	quote := writeFile(t, dir, "quote.mk", "quote(1 + 2)")
+	// claims to have 1<<30 bytes of instructions
+	truncated := writeFile(t, dir, "truncated.mkc",
+		compiler.BytecodeMagic+string([]byte{0, compiler.BytecodeVersion})+"\x00\x80\x80\x80\x80\x04")
+	invalid := writeBytecode(t, dir, "invalid.mkc", &compiler.Bytecode{
+		Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpPop), code.Make(code.OpReturn)),
+	})
+
+	tests := []struct {
+		args           []string
+		stdin          string
+		expectedStatus int
+		expectedStdout string // contained in the output, if set
+		expectedStderr string
+	}{
+		{[]string{"help"}, "", exitOK, "Usage:", ""},
+		{[]string{"frobnicate"}, "", exitUsage, "", `unknown command "frobnicate"`},
+		{[]string{"run"}, "", exitUsage, "", "missing script file"},
+		{[]string{"run", "--nope", hello}, "", exitUsage, "", "flag provided but not defined"},
+		{[]string{"run", filepath.Join(dir, "missing.mk")}, "", exitUsage, "", "no such file"},
+		{[]string{"run", hello, "world"}, "", exitOK, "hello world", ""},
+		{[]string{"run", "--engine=eval", hello, "world"}, "", exitOK, "hello world", ""},
+		{[]string{"run", "--engine=jit", hello}, "", exitUsage, "", `unknown engine "jit"`},
+		{[]string{"run", "-O", hello, "world"}, "", exitOK, "hello world", ""},
+		{[]string{"run", syntax}, "", exitParse, "", "error[P0002]"},
+		{[]string{"run", "--engine=eval", syntax}, "", exitParse, "", "error[P0002]"},
+		{[]string{"run", failing}, "", exitRuntime, "", "failing.mk:1:17: division by zero"},
+		{[]string{"run", "--engine=eval", failing}, "", exitRuntime, "", "stack trace:"},
+		{[]string{"run", compileErr}, "", exitCompile, "", "cannot assign to builtin len"},
+		{[]string{"run", truncated}, "", exitCompile, "", "not a monkey bytecode file"},
+		{[]string{"run", invalid}, "", exitCompile, "", "OpReturn outside of a function"},
+		{[]string{"eval", "-e", "1 + 2"}, "", exitOK, "3\n", ""},
+		{[]string{"eval", "--engine=eval", "-e", "len(args)", "a", "b"}, "", exitOK, "2\n", ""},
+		{[]string{"eval", "-O", "-e", "60 * 60 * 24"}, "", exitOK, "86400\n", ""},
+		{[]string{"eval"}, "", exitUsage, "", "missing -e CODE"},
+		{[]string{"eval", "-e", "1 +"}, "", exitParse, "", "error[P0002]"},
+		{[]string{"eval", "-e", `"a" < "b"`}, "", exitRuntime, "", "-e:1:1: unknown operator: STRING < STRING"},
+		{[]string{"build"}, "", exitUsage, "", "need exactly one script file"},
+		{[]string{"build", syntax}, "", exitParse, "", "error[P0002]"},
+		{[]string{"build", quote}, "", exitCompile, "", "quote.mk:1:7: code using quote can't be saved as bytecode"},
+		{[]string{"disasm", hello}, "", exitOK, "OpGetBuiltin", ""},
+		{[]string{"disasm", truncated}, "", exitCompile, "", "not a monkey bytecode file"},
+		{[]string{"repl"}, "let x = 2;\nx * 21\n", exitOK, "42", ""},
+		{[]string{"repl"}, "let x = 2;\n", exitOK, ">> >> ", ""},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		status := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+		if status != tt.expectedStatus {
+			t.Errorf("%q: wrong exit status. want=%d, got=%d (stderr %q)",
+				tt.args, tt.expectedStatus, status, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), tt.expectedStdout) {
+			t.Errorf("%q: wrong output. want %q in %q", tt.args, tt.expectedStdout, stdout.String())
+		}
+		if !strings.Contains(stderr.String(), tt.expectedStderr) {
+			t.Errorf("%q: wrong error output. want %q in %q", tt.args, tt.expectedStderr, stderr.String())
+		}
+		if tt.expectedStderr == "" && stderr.Len() > 0 {
+			t.Errorf("%q: unexpected error output %q", tt.args, stderr.String())
+		}
+	}
+}
+
+func TestBuildAndRun(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	script := writeFile(t, dir, "script.mk", `
+let double = fn(x) { x * 2 };
+puts(double(len(args)));
+double(21)`)
+	built := filepath.Join(dir, "script.mkc")
+
+	for _, flags := range [][]string{{}, {"-O"}, {"--strip"}} {
+		var stdout, stderr bytes.Buffer
+		args := append([]string{"build"}, flags...)
+		if status := run(append(args, script), nil, &stdout, &stderr); status != exitOK {
+			t.Fatalf("build %q: wrong exit status %d (stderr %q)", flags, status, stderr.String())
+		}
+
+		status := run([]string{"run", built, "a", "b"}, nil, &stdout, &stderr)
+		if status != exitOK {
+			t.Fatalf("run %q: wrong exit status %d (stderr %q)", flags, status, stderr.String())
+		}
+		if stdout.String() != "4\n" {
+			t.Errorf("run %q: wrong output. want=%q, got=%q", flags, "4\n", stdout.String())
+		}
+	}
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "monkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func writeBytecode(t *testing.T, dir, name string, bytecode *compiler.Bytecode) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := bytecode.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, dir, name, buf.String())
+}
+
+func concat(ins ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, in := range ins {
+		out = append(out, in...)
+	}
+	return out
+}
@@ -65,10 +65,12 @@ const (
 	OpSub
 	OpMul
 	OpDiv
+	OpMod
 
 	OpEqual
 	OpNotEqual
 	OpGreaterThan
+	OpGreaterThanOrEqual
 
 	OpBang
 	OpMinus
@@ -85,6 +87,7 @@ const (
 	OpArray
 	OpHash
 	OpIndex
+	OpSetIndex
 
 	OpCall
 	OpReturnValue
@@ -93,7 +96,20 @@ const (
 	OpGetBuiltin
 	OpClosure
 	OpGetFree
+	OpSetFree
+	OpGetLocalCell
+	OpGetFreeCell
+	OpCurrentClosure
 
+	OpIter
+	OpIterNext
+
+	OpThrow
+
+	OpInterpolate
+
+	OpLessThan
+	OpLessThanOrEqual
 )
 
 // Definition helps make our opcodes readable and
@@ -116,10 +132,12 @@ var definitions = map[Opcode]*Definition{
 	OpSub: {"OpSub", []int{}},
 	OpMul: {"OpMul", []int{}},
 	OpDiv: {"OpDiv", []int{}},
+	OpMod: {"OpMod", []int{}},
 
-	OpEqual:       {"OpEqual", []int{}},
-	OpNotEqual:    {"OpNotEqual", []int{}},
-	OpGreaterThan: {"OpGreaterThan", []int{}},
+	OpEqual:              {"OpEqual", []int{}},
+	OpNotEqual:           {"OpNotEqual", []int{}},
+	OpGreaterThan:        {"OpGreaterThan", []int{}},
+	OpGreaterThanOrEqual: {"OpGreaterThanOrEqual", []int{}},
 
 	OpBang:  {"OpBang", []int{}},
 	OpMinus: {"OpMinus", []int{}},
@@ -136,18 +154,52 @@ var definitions = map[Opcode]*Definition{
 	OpArray: {"OpArray", []int{2}}, // max len of list is 2^16
 	OpHash:  {"OpHash", []int{2}},
 	OpIndex: {"OpIndex", []int{}},
+	// OpSetIndex pops a value, an index and the array or hash below them and
+	// pushes the value after assigning it
+	OpSetIndex: {"OpSetIndex", []int{}},
 
 	OpCall:        {"OpCall", []int{1}},
 	OpReturnValue: {"OpReturnValue", []int{}},
 	OpReturn:      {"OpReturn", []int{}},
 
-	OpGetBuiltin: {"OpGetBuiltin", []int{1}}, // 256 possible builtins
+	OpGetBuiltin: {"OpGetBuiltin", []int{2}}, // 65536 possible builtins
 
 	// OpClosure has 2 operands:
 	// 1) constant index which specifies where in the constant pool the func is
 	// 2) number of free variables needed for the closure
 	OpClosure: {"OpClosure", []int{2, 1}},
 	OpGetFree: {"OpGetFree", []int{1}},
+	OpSetFree: {"OpSetFree", []int{1}},
+
+	// OpGetLocalCell and OpGetFreeCell push the cell of a variable for a
+	// closure to capture, a local is turned into a cell the first time
+	OpGetLocalCell: {"OpGetLocalCell", []int{1}},
+	OpGetFreeCell:  {"OpGetFreeCell", []int{1}},
+
+	// OpCurrentClosure pushes the closure currently being executed, it is
+	// how a function refers to itself
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	// OpIter replaces the array, hash or string on top of the stack with an
+	// iterator over its elements, keys or characters
+	OpIter: {"OpIter", []int{}},
+	// OpIterNext pushes the next value of the iterator on top of the stack,
+	// which stays there. Once the iterator is done, it jumps to its operand.
+	OpIterNext: {"OpIterNext", []int{2}},
+
+	// OpThrow pops a value and throws it as an exception. Errors are thrown
+	// again as they are, finally blocks use that to pass exceptions on.
+	OpThrow: {"OpThrow", []int{}},
+
+	// OpInterpolate replaces the number of values given by its operand with
+	// a string joining them, as they are inspected
+	OpInterpolate: {"OpInterpolate", []int{2}},
+
+	// OpLessThan and OpLessThanOrEqual compare the two values on top of the
+	// stack like their greater than counterparts, so the operands of < and
+	// <= are evaluated from left to right as well
+	OpLessThan:        {"OpLessThan", []int{}},
+	OpLessThanOrEqual: {"OpLessThanOrEqual", []int{}},
 }
 
 // Lookup looks up an Opcode definition via our definition map
@@ -160,6 +212,16 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
+// LookupName finds an Opcode by its human-readable name, e.g. "OpConstant"
+func LookupName(name string) (Opcode, *Definition, bool) {
+	for op, def := range definitions {
+		if def.Name == name {
+			return op, def, true
+		}
+	}
+	return 0, nil, false
+}
+
 // Make takes an opcode byte and int operands and converts
 // them into a slice of bytes repr'ing our bytecode
 // Assembling from human readable instructions to our VM bytecode
@@ -1,6 +1,56 @@
 package code
 
-import "testing"
+import (
+	"monkey/token"
+	"testing"
+)
+
+func TestSourceMap(t *testing.T) {
+	pos := func(line, column int) token.Position {
+		return token.Position{Line: line, Column: column}
+	}
+
+	var m SourceMap
+	m = m.Add(0, pos(1, 1))
+	m = m.Add(3, pos(1, 1)) // same position, no new entry
+	m = m.Add(5, pos(2, 3))
+	m = m.Add(8, pos(3, 1))
+
+	if len(m) != 3 {
+		t.Fatalf("wrong number of entries. want=3, got=%d", len(m))
+	}
+
+	m = m.Truncate(8)
+	m = m.Add(8, pos(4, 1))
+
+	tests := []struct {
+		offset   int
+		expected token.Position
+		found    bool
+	}{
+		{0, pos(1, 1), true},
+		{4, pos(1, 1), true},
+		{5, pos(2, 3), true},
+		{7, pos(2, 3), true},
+		{8, pos(4, 1), true},
+		{100, pos(4, 1), true},
+		{-1, token.Position{}, false},
+	}
+
+	for _, tt := range tests {
+		got, found := m.Lookup(tt.offset)
+		if got != tt.expected || found != tt.found {
+			t.Errorf("wrong position for offset %d. want=%s (%t), got=%s (%t)",
+				tt.offset, tt.expected, tt.found, got, found)
+		}
+	}
+
+	// replacing the position at the last offset merges equal entries
+	m = m.Add(8, pos(2, 3))
+	if len(m) != 2 {
+		t.Errorf("entries with equal positions were not merged. got=%+v", m)
+	}
+}
 
 // TestMake tests whether make can generate the  bytecode output
 // that we expect to be associated with the Opcode and it's operands
@@ -15,6 +65,11 @@ func TestMake(t *testing.T) {
 			[]int{65534},
 			[]byte{byte(OpConstant), 255, 254},
 		},
+		{
+			OpGetBuiltin,
+			[]int{300},
+			[]byte{byte(OpGetBuiltin), 1, 44},
+		},
 		{
 			OpAdd,
 			[]int{},
@@ -0,0 +1,29 @@
+package code
+
+// Handler catches exceptions thrown by the instructions in [Start, End).
+// The stack is cut down to Depth values above the locals of the frame, the
+// exception is pushed and execution continues at Target. Catch handlers
+// are pushed the thrown value, finally handlers the error itself so they
+// can throw it again once they are done.
+type Handler struct {
+	Start   int
+	End     int
+	Target  int
+	Depth   int
+	Finally bool
+}
+
+// Handlers lists the exception handlers of a function. Handlers of nested
+// try blocks come before the ones of the blocks enclosing them, so the
+// first one covering an instruction is the innermost.
+type Handlers []Handler
+
+// Lookup returns the innermost handler covering the instruction at offset
+func (hs Handlers) Lookup(offset int) (Handler, bool) {
+	for _, h := range hs {
+		if h.Start <= offset && offset < h.End {
+			return h, true
+		}
+	}
+	return Handler{}, false
+}
@@ -0,0 +1,57 @@
+package code
+
+import (
+	"monkey/token"
+	"sort"
+)
+
+// SourceMapEntry marks the instruction at Offset as the first one that was
+// compiled from the source code at Pos
+type SourceMapEntry struct {
+	Offset int
+	Pos    token.Position
+}
+
+// SourceMap maps offsets into Instructions back to positions in the source
+// code. The entries are sorted by offset and an entry covers every
+// instruction up to the offset of the next one, so only the offsets where
+// the position changes are stored.
+type SourceMap []SourceMapEntry
+
+// Add records that the instruction at offset was compiled from pos
+func (m SourceMap) Add(offset int, pos token.Position) SourceMap {
+	if n := len(m); n > 0 {
+		if m[n-1].Offset == offset {
+			m[n-1].Pos = pos
+			return m.compact()
+		}
+		if m[n-1].Pos == pos {
+			return m
+		}
+	}
+	return append(m, SourceMapEntry{Offset: offset, Pos: pos})
+}
+
+// Truncate drops the entries of instructions at or after offset, it keeps
+// the map in sync when instructions are removed from the end
+func (m SourceMap) Truncate(offset int) SourceMap {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Offset >= offset })
+	return m[:i]
+}
+
+// Lookup returns the position the instruction at offset was compiled from
+func (m SourceMap) Lookup(offset int) (token.Position, bool) {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}, false
+	}
+	return m[i-1].Pos, true
+}
+
+// compact merges the last entry into the one before if they share a position
+func (m SourceMap) compact() SourceMap {
+	if n := len(m); n > 1 && m[n-1].Pos == m[n-2].Pos {
+		return m[:n-1]
+	}
+	return m
+}
@@ -5,6 +5,7 @@ import (
 	"monkey/ast"
 	"monkey/code"
 	"monkey/object"
+	"monkey/token"
 	"sort"
 )
 
@@ -16,6 +17,10 @@ type Compiler struct {
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	pos token.Position // position of the node being compiled
+
+	optimize bool // see SetOptimize
 }
 
 func New() *Compiler {
@@ -25,10 +30,7 @@ func New() *Compiler {
 		previousInstruction: EmittedInstruction{},
 	}
 
-	symbolTable := NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	symbolTable := NewSymbolTableWithBuiltins(object.Builtins)
 
 	return &Compiler{
 		constants:   []object.Object{},
@@ -47,6 +49,13 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 
 // Compile our ast down to it's respective bytecode representation.
 func (c *Compiler) Compile(node ast.Node) error {
+	// instructions emitted for node map back to its position
+	if pos := node.Pos(); pos.IsValid() {
+		outer := c.pos
+		c.pos = pos
+		defer func() { c.pos = outer }()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -55,6 +64,14 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 		}
+
+		// the result of a program is the value its last instruction pops.
+		// Like in the evaluator, programs that don't end with an expression
+		// statement, but with a let statement or a loop, result in null
+		if !endsWithExpression(node) {
+			c.emit(code.OpNull)
+			c.emit(code.OpPop)
+		}
 	case *ast.BlockStatement:
 		for _, s := range node.Statements {
 			err := c.Compile(s)
@@ -68,10 +85,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
+		c.hold(1)
 		err = c.Compile(node.Index)
 		if err != nil {
 			return err
 		}
+		c.hold(-1)
 
 		c.emit(code.OpIndex)
 	case *ast.HashLiteral:
@@ -88,19 +107,34 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
+			c.hold(1)
 			err = c.Compile(node.Pairs[k])
 			if err != nil {
 				return err
 			}
+			c.hold(1)
 		}
+		c.hold(-len(node.Pairs) * 2)
 		c.emit(code.OpHash, len(node.Pairs)*2)
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			err := c.Compile(part)
+			if err != nil {
+				return err
+			}
+			c.hold(1)
+		}
+		c.hold(-len(node.Parts))
+		c.emit(code.OpInterpolate, len(node.Parts))
 	case *ast.ArrayLiteral:
 		for _, el := range node.Elements {
 			err := c.Compile(el)
 			if err != nil {
 				return err
 			}
+			c.hold(1)
 		}
+		c.hold(-len(node.Elements))
 		c.emit(code.OpArray, len(node.Elements))
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Expression)
@@ -118,26 +152,33 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return fmt.Errorf("%s: undefined variable %s", node.Pos(), node.Value)
 		}
 
 		c.loadSymbol(symbol)
 	case *ast.LetStatement:
-		// having this here allows a functions name to be bound
-		// before its body is compiled. This allows the functions
-		// body to self reference / call itself
-		symbol := c.symbolTable.Define(node.Name.Value)
+		// the name is only bound after the value is compiled. Functions
+		// calling themselves resolve their name in the FunctionScope, so
+		// recursion works for local functions as well
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
 
+		symbol := c.symbolTable.Define(node.Name.Value)
+
 		if symbol.Scope == GlobalScope {
 			c.emit(code.OpSetGlobal, symbol.Index)
 		} else {
 			c.emit(code.OpSetLocal, symbol.Index)
 		}
 	case *ast.IfExpression:
+		if c.optimize {
+			if truthy, ok := isTruthy(fold(node.Condition)); ok {
+				return c.compileConstantIf(node, truthy)
+			}
+		}
+
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
@@ -154,9 +195,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
+		c.keepBlockValue()
 
 		// emit an OpJump with a bogus value that we'll back patch
 		jumpPos := c.emit(code.OpJump, 9999)
@@ -174,9 +213,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
+			c.keepBlockValue()
 		}
 
 		// Back patch our unconditional jump address to the position after the
@@ -184,30 +221,27 @@ func (c *Compiler) Compile(node ast.Node) error {
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			// re-ordering our < to a > by switching the order of operands
-			err := c.Compile(node.Right)
-			if err != nil {
-				return err
-			}
-
-			err = c.Compile(node.Left)
-			if err != nil {
-				return err
+		if c.optimize {
+			if folded := fold(node); folded != node {
+				return c.Compile(folded)
 			}
+		}
 
-			c.emit(code.OpGreaterThan)
-			return nil
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogical(node)
 		}
+
 		err := c.Compile(node.Left)
 		if err != nil {
 			return err
 		}
 
+		c.hold(1)
 		err = c.Compile(node.Right)
 		if err != nil {
 			return err
 		}
+		c.hold(-1)
 
 		switch node.Operator {
 		case "+":
@@ -218,16 +252,30 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanOrEqual)
+		case "<":
+			c.emit(code.OpLessThan)
+		case "<=":
+			c.emit(code.OpLessThanOrEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
 		}
 	case *ast.PrefixExpression:
+		if c.optimize {
+			if folded := fold(node); folded != node {
+				return c.Compile(folded)
+			}
+		}
+
 		err := c.Compile(node.Right)
 		if err != nil {
 			return err
@@ -239,7 +287,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
 		}
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
@@ -251,6 +299,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// bytecode constant instruction with reference to the index.
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -260,6 +311,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.FunctionLiteral:
 		c.enterScope()
 
+		if node.Name != "" {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+
 		for _, p := range node.Parameters {
 			c.symbolTable.Define(p.Value)
 		}
@@ -281,50 +336,497 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// right before we leave our scope we'll save how many local
 		// definitions we encountered so we can emit it in the instructions
 		numLocals := c.symbolTable.numDefinitions
+		sourceMap := c.currentSourceMap()
+		handlers := c.scopes[c.scopeIndex].handlers
 		instructions := c.leaveScope()
 
-		// emit instructions for getting all of our free variables
+		// emit instructions for getting the cells of all of our free variables
 		for _,s := range freeSymbols {
-			c.loadSymbol(s)
+			c.loadCell(s)
+		}
+
+		freeNames := make([]string, len(freeSymbols))
+		for i, s := range freeSymbols {
+			freeNames[i] = s.Name
 		}
 
 		compiledFn := &object.CompiledFunction{
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+			SourceMap:     sourceMap,
+			FreeNames:     freeNames,
+			Handlers:      handlers,
 		}
 
 		fnIndex := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
 	case *ast.ReturnStatement:
+		// in the main program, OpReturnValue halts the VM with the value
+		// as the result of the program
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
 			return err
 		}
+
+		// the finally blocks run after the value is computed
+		tries := len(c.scopes[c.scopeIndex].tries)
+		c.hold(1)
+		err = c.exitTries(tries)
+		if err != nil {
+			return err
+		}
+		c.hold(-1)
+
 		c.emit(code.OpReturnValue)
+		c.reenterTries(tries)
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	case *ast.TryExpression:
+		return c.compileTry(node)
 
 	case *ast.CallExpression:
+		if node.Function.TokenLiteral() == "quote" && len(node.Arguments) == 1 {
+			return c.compileQuote(node)
+		}
+
 		err := c.Compile(node.Function)
 		if err != nil {
 			return err
 		}
+		c.hold(1)
 
 		for _, a := range node.Arguments {
 			err := c.Compile(a)
 			if err != nil {
 				return err
 			}
+			c.hold(1)
 		}
+		c.hold(-len(node.Arguments) - 1)
 
 		c.emit(code.OpCall, len(node.Arguments))
+	case *ast.AssignExpression:
+		return c.compileAssign(node)
+	case *ast.WhileStatement:
+		return c.compileWhile(node)
+	case *ast.ForStatement:
+		return c.compileFor(node)
+	case *ast.BranchStatement:
+		return c.compileBranch(node)
+	case *ast.MacroLiteral:
+		return fmt.Errorf("%s: macros must be defined by a top-level let statement"+
+			" and expanded before compiling", node.Pos())
+	case *ast.BadExpression, *ast.BadStatement:
+		return fmt.Errorf("%s: cannot compile code with syntax errors", node.Pos())
+	}
+	return nil
+}
+
+// compileQuote compiles a call of quote outside of a macro to a constant
+// holding the quoted code. Unquoting needs the evaluator, macros are
+// expanded before compiling, so it is only supported inside of macros.
+func (c *Compiler) compileQuote(call *ast.CallExpression) error {
+	var unquote ast.Node
+	ast.Modify(call.Arguments[0], func(node ast.Node) ast.Node {
+		if call, ok := node.(*ast.CallExpression); ok &&
+			call.Function.TokenLiteral() == "unquote" && unquote == nil {
+			unquote = call
+		}
+		return node
+	})
+	if unquote != nil {
+		return fmt.Errorf("%s: unquote is only supported inside of macros", unquote.Pos())
 	}
+
+	quote := &object.Quote{Node: call.Arguments[0]}
+	c.emit(code.OpConstant, c.addConstant(quote))
 	return nil
 }
 
+// keepBlockValue leaves the value of the block just compiled on the stack.
+// Blocks that don't end with an expression statement evaluate to null.
+func (c *Compiler) keepBlockValue() {
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpNull)
+	}
+}
+
+// compileLogical compiles && and || with jumps, the right operand is only
+// run if the left one doesn't decide the result already. Both evaluate to
+// true or false, the right operand is converted by negating it twice.
+func (c *Compiler) compileLogical(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if node.Operator == "||" {
+		c.emit(code.OpTrue)
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+		if err := c.compileTruthiness(node.Right); err != nil {
+			return err
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+
+	if err := c.compileTruthiness(node.Right); err != nil {
+		return err
+	}
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	c.emit(code.OpFalse)
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileTruthiness compiles an expression that evaluates to whether the
+// value of node is truthy
+func (c *Compiler) compileTruthiness(node ast.Expression) error {
+	err := c.Compile(node)
+	if err != nil {
+		return err
+	}
+
+	if !c.optimize || !isBoolean(node) {
+		c.emit(code.OpBang)
+		c.emit(code.OpBang)
+	}
+	return nil
+}
+
+// hold counts the values the expression being compiled keeps on the stack
+// while its operands are compiled, break and continue have to pop them
+func (c *Compiler) hold(n int) {
+	c.scopes[c.scopeIndex].held += n
+}
+
+// compileWhile compiles a while loop, the condition is checked before
+// every iteration and continue jumps back to it
+func (c *Compiler) compileWhile(node *ast.WhileStatement) error {
+	start := len(c.currentInstructions())
+
+	err := c.Compile(node.Condition)
+	if err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	err = c.compileLoopBody(node.Body, start, 0)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpJump, start)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	c.patchBreaks()
+	return nil
+}
+
+// compileFor compiles a for loop. The iterator stays on the stack while
+// the loop runs, OpIterNext pops it once it is done.
+func (c *Compiler) compileFor(node *ast.ForStatement) error {
+	err := c.Compile(node.Iterable)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+
+	next := c.emit(code.OpIterNext, 9999)
+
+	// the variable is bound like a let statement in the enclosing scope
+	symbol := c.symbolTable.Define(node.Variable.Value)
+	if symbol.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, symbol.Index)
+	} else {
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+
+	err = c.compileLoopBody(node.Body, next, 1)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpJump, next)
+	c.changeOperand(next, len(c.currentInstructions()))
+	c.patchBreaks()
+	return nil
+}
+
+// compileLoopBody compiles the body of a loop whose continue target is
+// start, with keep values of the loop itself held on the stack
+func (c *Compiler) compileLoopBody(body *ast.BlockStatement, start, keep int) error {
+	scope := &c.scopes[c.scopeIndex]
+	scope.held += keep
+	scope.loops = append(scope.loops, &loop{
+		start: start,
+		held:  scope.held,
+		keep:  keep,
+	})
+
+	err := c.Compile(body)
+
+	scope = &c.scopes[c.scopeIndex]
+	scope.held -= keep
+	return err
+}
+
+// patchBreaks back-patches the breaks of the innermost loop to jump to the
+// current position and leaves the loop
+func (c *Compiler) patchBreaks() {
+	scope := &c.scopes[c.scopeIndex]
+	l := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+}
+
+// compileBranch compiles break and continue. The values held on the stack
+// since the loop started are popped before jumping, break also pops the
+// ones the loop keeps.
+func (c *Compiler) compileBranch(node *ast.BranchStatement) error {
+	scope := &c.scopes[c.scopeIndex]
+	if len(scope.loops) == 0 {
+		return fmt.Errorf("%s: %s outside of a loop", node.Pos(), node.TokenLiteral())
+	}
+	l := scope.loops[len(scope.loops)-1]
+
+	// the tries started inside of the loop are left as well
+	tries := 0
+	for _, t := range scope.tries {
+		if t.loops == len(scope.loops) {
+			tries++
+		}
+	}
+	err := c.exitTries(tries)
+	if err != nil {
+		return err
+	}
+	defer c.reenterTries(tries)
+
+	scope = &c.scopes[c.scopeIndex]
+	if node.Token.Type == token.CONTINUE {
+		for i := l.held; i < scope.held; i++ {
+			c.emit(code.OpPop)
+		}
+		c.emit(code.OpJump, l.start)
+		return nil
+	}
+
+	for i := l.held - l.keep; i < scope.held; i++ {
+		c.emit(code.OpPop)
+	}
+	l.breaks = append(l.breaks, c.emit(code.OpJump, 9999))
+	return nil
+}
+
+// compileTry compiles a try expression. The try and catch blocks are
+// covered by handlers in the function's handler table, which jump to the
+// catch block or to a copy of the finally block that throws the exception
+// again afterwards. Blocks that complete normally run the finally block
+// inline before jumping to the end.
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	scope := &c.scopes[c.scopeIndex]
+	t := &tryBlock{
+		finally: node.Finally,
+		held:    scope.held,
+		loops:   len(scope.loops),
+	}
+
+	err := c.compileProtected(t, node.Block)
+	if err != nil {
+		return err
+	}
+	protected := t.protected
+
+	jumps := []int{}
+	if node.Finally != nil {
+		err := c.compileFinally(node.Finally)
+		if err != nil {
+			return err
+		}
+	}
+	jumps = append(jumps, c.emit(code.OpJump, 9999))
+
+	if node.Catch != nil {
+		c.addHandlers(protected, code.Handler{
+			Target: len(c.currentInstructions()),
+			Depth:  t.held,
+		})
+
+		// the exception is bound like a let statement
+		symbol := c.symbolTable.Define(node.Parameter.Value)
+		if symbol.Scope == GlobalScope {
+			c.emit(code.OpSetGlobal, symbol.Index)
+		} else {
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+
+		if node.Finally != nil {
+			// exceptions thrown by the catch block run the finally block
+			t.protected = nil
+			err = c.compileProtected(t, node.Catch)
+			protected = t.protected
+		} else {
+			err = c.compileBlockValue(node.Catch)
+		}
+		if err != nil {
+			return err
+		}
+
+		if node.Finally != nil {
+			err := c.compileFinally(node.Finally)
+			if err != nil {
+				return err
+			}
+		}
+		jumps = append(jumps, c.emit(code.OpJump, 9999))
+	}
+
+	if node.Finally != nil {
+		c.addHandlers(protected, code.Handler{
+			Target:  len(c.currentInstructions()),
+			Depth:   t.held,
+			Finally: true,
+		})
+
+		err := c.compileFinally(node.Finally)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	}
+
+	for _, pos := range jumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// compileProtected compiles the value of block with the try t covering it,
+// the ranges of instructions it protects are collected in t.protected
+func (c *Compiler) compileProtected(t *tryBlock, block *ast.BlockStatement) error {
+	scope := &c.scopes[c.scopeIndex]
+	scope.tries = append(scope.tries, t)
+	c.protect(t)
+
+	err := c.compileBlockValue(block)
+
+	c.unprotect(t)
+	scope = &c.scopes[c.scopeIndex]
+	scope.tries = scope.tries[:len(scope.tries)-1]
+	return err
+}
+
+// compileFinally compiles a finally block while the value of the try, or
+// the exception, is kept on the stack
+func (c *Compiler) compileFinally(block *ast.BlockStatement) error {
+	c.hold(1)
+	err := c.Compile(block)
+	c.hold(-1)
+	return err
+}
+
+// compileBlockValue compiles block and leaves its value on the stack
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	start := len(c.currentInstructions())
+
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+
+	// keepBlockValue can't tell an empty block from the instructions
+	// emitted before it
+	if len(c.currentInstructions()) == start {
+		c.emit(code.OpNull)
+	} else {
+		c.keepBlockValue()
+	}
+	return nil
+}
+
+// addHandlers adds a handler like h for each of the ranges
+func (c *Compiler) addHandlers(ranges []code.Handler, h code.Handler) {
+	scope := &c.scopes[c.scopeIndex]
+	for _, r := range ranges {
+		h.Start, h.End = r.Start, r.End
+		scope.handlers = append(scope.handlers, h)
+	}
+}
+
+// protect starts a range of instructions protected by t
+func (c *Compiler) protect(t *tryBlock) {
+	t.start = len(c.currentInstructions())
+}
+
+// unprotect ends the range of instructions protected by t
+func (c *Compiler) unprotect(t *tryBlock) {
+	if end := len(c.currentInstructions()); end > t.start {
+		t.protected = append(t.protected, code.Handler{Start: t.start, End: end})
+	}
+}
+
+// exitTries leaves the innermost n tries for return, break or continue,
+// their finally blocks are run in between. The instructions up to the
+// matching reenterTries aren't protected by the tries left.
+func (c *Compiler) exitTries(n int) error {
+	scope := &c.scopes[c.scopeIndex]
+	tries, loops := scope.tries, scope.loops
+
+	for i := len(tries) - 1; i >= len(tries)-n; i-- {
+		t := tries[i]
+		c.unprotect(t)
+		if t.finally == nil {
+			continue
+		}
+
+		// the finally block is compiled as if it was where it is written
+		scope := &c.scopes[c.scopeIndex]
+		scope.tries = tries[:i:i]
+		scope.loops = loops[:t.loops:t.loops]
+
+		err := c.Compile(t.finally)
+
+		scope = &c.scopes[c.scopeIndex]
+		scope.tries, scope.loops = tries, loops
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reenterTries continues protecting instructions by the innermost n tries
+func (c *Compiler) reenterTries(n int) {
+	tries := c.scopes[c.scopeIndex].tries
+	for _, t := range tries[len(tries)-n:] {
+		c.protect(t)
+	}
+}
+
 func (c *Compiler) ByteCode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		SourceMap:    c.currentSourceMap(),
+		Handlers:     c.scopes[c.scopeIndex].handlers,
 	}
 }
 
@@ -353,6 +855,11 @@ func (c *Compiler) addInstruction(ins []byte) int {
 	// Check what scope we're currently in, and update the instructions there
 	c.scopes[c.scopeIndex].instructions = updatedInstructions
 
+	if c.pos.IsValid() {
+		scope := &c.scopes[c.scopeIndex]
+		scope.sourceMap = scope.sourceMap.Add(posNewInstruction, c.pos)
+	}
+
 	return posNewInstruction
 }
 
@@ -383,6 +890,7 @@ func (c *Compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.scopes[c.scopeIndex].sourceMap = c.currentSourceMap().Truncate(last.Position)
 }
 
 // currentInstructions gets the current instructions for the current scopeIndex
@@ -390,6 +898,11 @@ func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
 
+// currentSourceMap gets the source map of the current scope's instructions
+func (c *Compiler) currentSourceMap() code.SourceMap {
+	return c.scopes[c.scopeIndex].sourceMap
+}
+
 // replaceInstruction allows us to replace an instruction at an arbritrary offset
 // in our instruction slice
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -454,12 +967,116 @@ func (c *Compiler) loadSymbol(s Symbol) {
 		c.emit(code.OpGetBuiltin, s.Index)
 	case FreeScope:
 		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// loadCell pushes the cell of a variable a closure captures, so assignments
+// by the closure and the function it is defined in are seen by both
+func (c *Compiler) loadCell(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpGetLocalCell, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFreeCell, s.Index)
+	default:
+		// the closure itself can't be assigned to, OpClosure puts it
+		// into a cell of its own
+		c.loadSymbol(s)
+	}
+}
+
+// compileAssign compiles an assignment to a variable, an array element or
+// a hash key. The assigned value is left on the stack.
+func (c *Compiler) compileAssign(node *ast.AssignExpression) error {
+	target, ok := node.Target.(*ast.Identifier)
+	if !ok {
+		return c.compileIndexAssign(node)
+	}
+
+	symbol, ok := c.symbolTable.Resolve(target.Value)
+	if !ok {
+		return fmt.Errorf("%s: undefined variable %s", target.Pos(), target.Value)
+	}
+
+	if symbol.Scope == BuiltinScope {
+		return fmt.Errorf("%s: cannot assign to builtin %s", target.Pos(), target.Value)
+	}
+	if c.isFunctionName(symbol) {
+		return fmt.Errorf("%s: cannot assign to function %s inside of its body",
+			target.Pos(), target.Value)
 	}
+
+	err := c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, symbol.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, symbol.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, symbol.Index)
+	}
+	c.loadSymbol(symbol)
+	return nil
+}
+
+// endsWithExpression reports whether the last statement of program is an
+// expression statement
+func endsWithExpression(program *ast.Program) bool {
+	if len(program.Statements) == 0 {
+		return false
+	}
+	_, ok := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+// isFunctionName reports whether symbol is the name of the function being
+// compiled or, captured as a free variable, of a function around it
+func (c *Compiler) isFunctionName(symbol Symbol) bool {
+	for table := c.symbolTable; symbol.Scope == FreeScope; table = table.Outer {
+		symbol = table.FreeSymbols[symbol.Index]
+	}
+	return symbol.Scope == FunctionScope
+}
+
+func (c *Compiler) compileIndexAssign(node *ast.AssignExpression) error {
+	target, ok := node.Target.(*ast.IndexExpression)
+	if !ok {
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target.String())
+	}
+
+	err := c.Compile(target.Left)
+	if err != nil {
+		return err
+	}
+
+	c.hold(1)
+	err = c.Compile(target.Index)
+	if err != nil {
+		return err
+	}
+
+	c.hold(1)
+	err = c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+	c.hold(-2)
+
+	c.emit(code.OpSetIndex)
+	return nil
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	SourceMap    code.SourceMap // positions of the main program's instructions
+	Handlers     code.Handlers  // exception handlers of the main program
 }
 
 type EmittedInstruction struct {
@@ -471,4 +1088,26 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	sourceMap           code.SourceMap
+	loops               []*loop       // the loops being compiled, innermost last
+	tries               []*tryBlock   // the try blocks being compiled, innermost last
+	held                int           // see hold
+	handlers            code.Handlers // exception handlers, innermost first
+}
+
+// loop is a while or for loop being compiled
+type loop struct {
+	start  int   // where continue jumps to
+	held   int   // values held on the stack when the body starts
+	keep   int   // of those, the values the loop itself keeps
+	breaks []int // positions of the jumps out of the loop to back-patch
+}
+
+// tryBlock is a try expression whose try or catch block is being compiled
+type tryBlock struct {
+	finally   *ast.BlockStatement // nil if there is no finally block
+	held      int                 // values held on the stack when the try starts
+	loops     int                 // loops being compiled when the try starts
+	start     int                 // start of the range being protected
+	protected []code.Handler      // Start and End of the ranges protected so far
 }
@@ -7,6 +7,7 @@ import (
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"reflect"
 	"testing"
 )
 
@@ -14,6 +15,581 @@ type compilerTestCase struct {
 	input                string
 	expectedConstants    []interface{}
 	expectedInstructions []code.Instructions
+	expectedHandlers     code.Handlers // of the main program
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"total: ${1 + 2}!"`,
+			expectedConstants: []interface{}{"total: ", 1, 2, "!"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpInterpolate, 3),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"${1}"`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpInterpolate, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "try { throw 1 } catch (e) { e }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpThrow),
+				// 0004
+				code.Make(code.OpNull),
+				// 0005
+				code.Make(code.OpJump, 17),
+				// 0008
+				code.Make(code.OpSetGlobal, 0),
+				// 0011
+				code.Make(code.OpGetGlobal, 0),
+				// 0014
+				code.Make(code.OpJump, 17),
+				// 0017
+				code.Make(code.OpPop),
+			},
+			expectedHandlers: code.Handlers{
+				{Start: 0, End: 5, Target: 8, Depth: 0},
+			},
+		},
+		{
+			input:             "try { 1 } finally { 2 }",
+			expectedConstants: []interface{}{1, 2, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpConstant, 1),
+				// 0006
+				code.Make(code.OpPop),
+				// 0007
+				code.Make(code.OpJump, 15),
+				// 0010
+				code.Make(code.OpConstant, 2),
+				// 0013
+				code.Make(code.OpPop),
+				// 0014
+				code.Make(code.OpThrow),
+				// 0015
+				code.Make(code.OpPop),
+			},
+			expectedHandlers: code.Handlers{
+				{Start: 0, End: 3, Target: 10, Depth: 0, Finally: true},
+			},
+		},
+		{
+			input:             "while (true) { try { break } finally { 1 } }",
+			expectedConstants: []interface{}{1, 1, 1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 28),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 28),
+				// 0011
+				code.Make(code.OpNull),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpPop),
+				// 0016
+				code.Make(code.OpJump, 24),
+				// 0019
+				code.Make(code.OpConstant, 2),
+				// 0022
+				code.Make(code.OpPop),
+				// 0023
+				code.Make(code.OpThrow),
+				// 0024
+				code.Make(code.OpPop),
+				// 0025
+				code.Make(code.OpJump, 0),
+				// 0028
+				code.Make(code.OpNull),
+				// 0029
+				code.Make(code.OpPop),
+			},
+			expectedHandlers: code.Handlers{
+				{Start: 11, End: 12, Target: 19, Depth: 0, Finally: true},
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x = 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { let x = 1; x = 2 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a) { fn() { a = 1 } }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocalCell, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = [1]; a[0] = 2;",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 1", "1:1: undefined variable x"},
+		{"len = 1", "1:1: cannot assign to builtin len"},
+		{"let f = fn() { f = 1 }", "1:16: cannot assign to function f inside of its body"},
+		{"let f = fn() { fn() { f = 1 } }", "1:23: cannot assign to function f inside of its body"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("%q: expected compiler error, got none", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%q: wrong error. expected=%q, got=%q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
+
+func TestControlFlowErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: break outside of a loop"},
+		{"fn() { while (true) { fn() { continue } } }", "1:30: continue outside of a loop"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("%q: expected compiler error, got none", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%q: wrong error. expected=%q, got=%q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { 1; break; continue; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 17),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 17),
+				// 0011
+				code.Make(code.OpJump, 0),
+				// 0014
+				code.Make(code.OpJump, 0),
+				// 0017
+				code.Make(code.OpNull),
+				// 0018
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "for (x in [1]) { x }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIter),
+				// 0007
+				code.Make(code.OpIterNext, 20),
+				// 0010
+				code.Make(code.OpSetGlobal, 0),
+				// 0013
+				code.Make(code.OpGetGlobal, 0),
+				// 0016
+				code.Make(code.OpPop),
+				// 0017
+				code.Make(code.OpJump, 7),
+				// 0020
+				code.Make(code.OpNull),
+				// 0021
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// break pops the operand of + and the iterator, continue
+			// only the operand
+			input:             "for (x in []) { 1 + if (x) { break } else { continue } }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpArray, 0),
+				// 0003
+				code.Make(code.OpIter),
+				// 0004
+				code.Make(code.OpIterNext, 38),
+				// 0007
+				code.Make(code.OpSetGlobal, 0),
+				// 0010
+				code.Make(code.OpConstant, 0),
+				// 0013
+				code.Make(code.OpGetGlobal, 0),
+				// 0016
+				code.Make(code.OpJumpNotTruthy, 28),
+				// 0019
+				code.Make(code.OpPop),
+				// 0020
+				code.Make(code.OpPop),
+				// 0021
+				code.Make(code.OpJump, 38),
+				// 0024
+				code.Make(code.OpNull),
+				// 0025
+				code.Make(code.OpJump, 33),
+				// 0028
+				code.Make(code.OpPop),
+				// 0029
+				code.Make(code.OpJump, 4),
+				// 0032
+				code.Make(code.OpNull),
+				// 0033
+				code.Make(code.OpAdd),
+				// 0034
+				code.Make(code.OpPop),
+				// 0035
+				code.Make(code.OpJump, 4),
+				// 0038
+				code.Make(code.OpNull),
+				// 0039
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLogicalExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 && 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0006
+				code.Make(code.OpConstant, 1),
+				// 0009
+				code.Make(code.OpBang),
+				// 0010
+				code.Make(code.OpBang),
+				// 0011
+				code.Make(code.OpJump, 15),
+				// 0014
+				code.Make(code.OpFalse),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 || 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0006
+				code.Make(code.OpTrue),
+				// 0007
+				code.Make(code.OpJump, 15),
+				// 0010
+				code.Make(code.OpConstant, 1),
+				// 0013
+				code.Make(code.OpBang),
+				// 0014
+				code.Make(code.OpBang),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 <= 2; 1 >= 2; 5 % 2",
+			expectedConstants: []interface{}{1, 2, 1, 2, 5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThanOrEqual),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSourceMap(t *testing.T) {
+	program := parse("let a = 1;\nfn(b) {\n  a * b\n};")
+
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.ByteCode()
+
+	fn, ok := bytecode.Constants[1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 1 is not a CompiledFunction. got=%T", bytecode.Constants[1])
+	}
+
+	tests := []struct {
+		sourceMap code.SourceMap
+		offset    int
+		expected  string
+	}{
+		{bytecode.SourceMap, 0, "1:9"},  // OpConstant 0
+		{bytecode.SourceMap, 3, "1:1"},  // OpSetGlobal 0
+		{bytecode.SourceMap, 6, "2:1"},  // OpClosure 1 0
+		{bytecode.SourceMap, 10, "2:1"}, // OpPop
+		{fn.SourceMap, 0, "3:3"},        // OpGetGlobal 0
+		{fn.SourceMap, 3, "3:7"},        // OpGetLocal 0
+		{fn.SourceMap, 5, "3:3"},        // OpMul
+		{fn.SourceMap, 6, "3:3"},        // OpReturnValue
+	}
+
+	for _, tt := range tests {
+		pos, ok := tt.sourceMap.Lookup(tt.offset)
+		if !ok || pos.String() != tt.expected {
+			t.Errorf("wrong position for offset %d. want=%s, got=%s",
+				tt.offset, tt.expected, pos)
+		}
+	}
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let countDown = fn(x) { countDown(x - 1); };
+			countDown(1);
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) { countDown(x - 1); };
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestQuote(t *testing.T) {
+	program := parse("quote(1 + 2)")
+
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.ByteCode()
+	quote, ok := bytecode.Constants[0].(*object.Quote)
+	if !ok {
+		t.Fatalf("constant is not a Quote. got=%T", bytecode.Constants[0])
+	}
+	if quote.Node.String() != "(1 + 2)" {
+		t.Errorf("wrong quoted code. got=%s", quote.Node.String())
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"quote(unquote(1) + 2)", "1:7: unquote is only supported inside of macros"},
+		{"fn() { macro(x) { x } }", "1:8: macros must be defined by a top-level let statement" +
+			" and expanded before compiling"},
+	}
+
+	for _, tt := range errorTests {
+		err := New().Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestCompilerErrorPositions(t *testing.T) {
+	program := parse("let x = 1;\nfn() {\n  x + y\n}")
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err == nil {
+		t.Fatalf("expected compiler error, got none")
+	}
+
+	expected := "3:7: undefined variable y"
+	if err.Error() != expected {
+		t.Errorf("wrong error. expected=%q, got=%q", expected, err.Error())
+	}
 }
 
 func TestClosures(t *testing.T) {
@@ -32,21 +608,21 @@ func TestClosures(t *testing.T) {
 			`,
 			expectedConstants: []interface{}{
 				[]code.Instructions{
-                    code.Make(code.OpGetFree, 0),
-                    code.Make(code.OpGetLocal, 0),
-                    code.Make(code.OpAdd),
-                    code.Make(code.OpReturnValue),
-                },
-                []code.Instructions{
-                    code.Make(code.OpGetLocal, 0),
-                    code.Make(code.OpClosure, 0, 1),
-                    code.Make(code.OpReturnValue),
-                },
-            },
-            expectedInstructions: []code.Instructions{
-                code.Make(code.OpClosure, 1, 0),
-                code.Make(code.OpPop),
-            },
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocalCell, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
 		},
 		{
 			input: `
@@ -72,9 +648,9 @@ func TestClosures(t *testing.T) {
 				},
 				[]code.Instructions{
 					// push outer free variable a
-					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetFreeCell, 0),
 					// push local parameter b
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					// innermost closure fn(c) {...} w/ # of free vars as 2
 					// notice we put 2 free vars on the sack above
 					code.Make(code.OpClosure, 0, 2),
@@ -82,7 +658,7 @@ func TestClosures(t *testing.T) {
 				},
 				[]code.Instructions{
 					// push an a on the stack so we can utilize it in our inner closure
-					code.Make(code.OpGetLocal),
+					code.Make(code.OpGetLocalCell, 0),
 					// middle closure w/ 1 free variable, a ref to the a above
 					code.Make(code.OpClosure, 1, 1),
 					code.Make(code.OpReturnValue),
@@ -134,9 +710,9 @@ func TestClosures(t *testing.T) {
 
 					// prep for the closure call w/ 2 free vars
 					// push a free variable onto the stack
-					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetFreeCell, 0),
 					// push our local on the stack
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 
 					code.Make(code.OpClosure, 4, 2),
 					code.Make(code.OpReturnValue),
@@ -144,7 +720,7 @@ func TestClosures(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 5, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -671,6 +1247,8 @@ func TestGlobalLetStatements(t *testing.T) {
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
 			},
 		},
 		{
@@ -755,6 +1333,30 @@ func TestConditionals(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input: `
+            if (true) { let x = 10; };
+            `,
+			expectedConstants: []interface{}{10},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpSetGlobal, 0),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpJump, 15),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -791,11 +1393,11 @@ func TestBooleanExpressions(t *testing.T) {
 		},
 		{
 			input:             "1 < 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
 				code.Make(code.OpPop),
 			},
 		},
@@ -943,6 +1545,11 @@ func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 		if err != nil {
 			t.Fatalf("testConstants failed: %s", err)
 		}
+
+		if !reflect.DeepEqual(bytecode.Handlers, tt.expectedHandlers) {
+			t.Fatalf("wrong handlers.\nwant=%+v\ngot =%+v",
+				tt.expectedHandlers, bytecode.Handlers)
+		}
 	}
 }
 
@@ -0,0 +1,438 @@
+package compiler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"monkey/code"
+	"monkey/object"
+	"monkey/token"
+)
+
+// Bytecode files start with a magic number and the version of the format.
+// The version has to be bumped whenever the layout or the meaning of
+// opcodes changes, files of other versions are rejected.
+//
+//	magic    "MNKY"
+//	version  uint16, big endian
+//	flags    byte, flagDebugInfo if source maps are included
+//	main     instructions, [source map], handlers
+//	pool     uvarint count, constants
+//
+// Integers, lengths and counts are varints. A constant is a tag byte
+// followed by its value, floats are stored as their IEEE 754 bits in big
+// endian, compiled functions hold their own instructions, source map and
+// handlers, followed by the names of their free variables if debug info
+// is included. Handlers are a count followed by the start, end, target and
+// depth of each handler and a byte that is 1 for finally handlers.
+const (
+	BytecodeMagic   = "MNKY"
+	BytecodeVersion = 9
+)
+
+var (
+	// ErrNotBytecode is returned when decoding data that isn't a bytecode file
+	ErrNotBytecode = errors.New("not a monkey bytecode file")
+	// ErrBytecodeVersion is returned when decoding a bytecode file written
+	// by an incompatible version of the compiler
+	ErrBytecodeVersion = errors.New("unsupported bytecode version")
+)
+
+const flagDebugInfo = 1 << 0
+
+// constant tags
+const (
+	tagInteger byte = iota + 1
+	tagString
+	tagCompiledFunction
+	tagFloat
+)
+
+// IsBytecode reports whether data starts like a bytecode file
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(BytecodeMagic))
+}
+
+// Encode writes the bytecode to w in the binary file format. Source maps
+// are included, use StripDebugInfo to leave them out. Quoted code is kept
+// as syntax tree, so programs that call quote can't be encoded.
+func (b *Bytecode) Encode(w io.Writer) error {
+	e := &encoder{w: bufio.NewWriter(w), filenames: map[string]int{}}
+
+	e.debug = b.SourceMap != nil
+	for _, c := range b.Constants {
+		if quote, ok := c.(*object.Quote); ok {
+			return fmt.Errorf("%s: code using quote can't be saved as bytecode", quote.Node.Pos())
+		}
+		if fn, ok := c.(*object.CompiledFunction); ok && (fn.SourceMap != nil || fn.FreeNames != nil) {
+			e.debug = true
+		}
+	}
+
+	e.w.WriteString(BytecodeMagic)
+	binary.Write(e.w, binary.BigEndian, uint16(BytecodeVersion))
+	var flags byte
+	if e.debug {
+		flags |= flagDebugInfo
+	}
+	e.w.WriteByte(flags)
+
+	e.instructions(b.Instructions, b.SourceMap)
+	e.handlers(b.Handlers)
+
+	e.uvarint(uint64(len(b.Constants)))
+	for i, c := range b.Constants {
+		if err := e.constant(c); err != nil {
+			return fmt.Errorf("constant %d: %s", i, err)
+		}
+	}
+
+	return e.w.Flush()
+}
+
+// StripDebugInfo returns a copy of the bytecode without source maps and
+// the names of free variables
+func (b *Bytecode) StripDebugInfo() *Bytecode {
+	constants := make([]object.Object, len(b.Constants))
+	for i, c := range b.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			stripped := *fn
+			stripped.SourceMap = nil
+			stripped.FreeNames = nil
+			c = &stripped
+		}
+		constants[i] = c
+	}
+
+	return &Bytecode{Instructions: b.Instructions, Constants: constants, Handlers: b.Handlers}
+}
+
+// Decode reads bytecode written by Encode. It only checks that the file is
+// well-formed, not that the code in it is safe to run: the operands,
+// jumps and exception handlers are taken as they are. Callers must verify
+// the result with (*vm.VM).Verify before running it, monkey.Interpreter's
+// LoadBytecode does so.
+func Decode(r io.Reader) (*Bytecode, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(BytecodeMagic))
+	if _, err := io.ReadFull(d.r, magic); err != nil || string(magic) != BytecodeMagic {
+		return nil, ErrNotBytecode
+	}
+
+	var version uint16
+	if err := binary.Read(d.r, binary.BigEndian, &version); err != nil {
+		return nil, d.fail(err)
+	}
+	if version != BytecodeVersion {
+		return nil, fmt.Errorf("%w: file has version %d, want %d",
+			ErrBytecodeVersion, version, BytecodeVersion)
+	}
+
+	flags, err := d.r.ReadByte()
+	if err != nil {
+		return nil, d.fail(err)
+	}
+	d.debug = flags&flagDebugInfo != 0
+
+	bytecode := &Bytecode{}
+	bytecode.Instructions, bytecode.SourceMap = d.instructions()
+	bytecode.Handlers = d.handlers()
+
+	count := d.length()
+	bytecode.Constants = []object.Object{}
+	for i := 0; i < count && d.err == nil; i++ {
+		bytecode.Constants = append(bytecode.Constants, d.constant())
+	}
+
+	if d.err != nil {
+		return nil, d.fail(d.err)
+	}
+	switch _, err := d.r.ReadByte(); err {
+	case io.EOF:
+	case nil:
+		return nil, fmt.Errorf("%w: trailing data after the constants", ErrNotBytecode)
+	default:
+		return nil, err
+	}
+	return bytecode, nil
+}
+
+type encoder struct {
+	w         *bufio.Writer
+	debug     bool
+	filenames map[string]int // filenames already written, by index
+}
+
+func (e *encoder) uvarint(x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	e.w.Write(buf[:n])
+}
+
+func (e *encoder) varint(x int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], x)
+	e.w.Write(buf[:n])
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.w.WriteString(s)
+}
+
+func (e *encoder) instructions(ins code.Instructions, sourceMap code.SourceMap) {
+	e.uvarint(uint64(len(ins)))
+	e.w.Write(ins)
+	if e.debug {
+		e.sourceMap(sourceMap)
+	}
+}
+
+// sourceMap writes the entries with offsets relative to the previous entry.
+// Filenames are written once and referred to by index afterwards.
+func (e *encoder) sourceMap(m code.SourceMap) {
+	e.uvarint(uint64(len(m)))
+
+	offset := 0
+	for _, entry := range m {
+		e.uvarint(uint64(entry.Offset - offset))
+		e.uvarint(uint64(entry.Pos.Offset))
+		e.uvarint(uint64(entry.Pos.Line))
+		e.uvarint(uint64(entry.Pos.Column))
+		offset = entry.Offset
+
+		if i, ok := e.filenames[entry.Pos.Filename]; ok {
+			e.uvarint(uint64(i))
+			continue
+		}
+		i := len(e.filenames)
+		e.filenames[entry.Pos.Filename] = i
+		e.uvarint(uint64(i))
+		e.string(entry.Pos.Filename)
+	}
+}
+
+func (e *encoder) handlers(hs code.Handlers) {
+	e.uvarint(uint64(len(hs)))
+	for _, h := range hs {
+		e.uvarint(uint64(h.Start))
+		e.uvarint(uint64(h.End))
+		e.uvarint(uint64(h.Target))
+		e.uvarint(uint64(h.Depth))
+		if h.Finally {
+			e.w.WriteByte(1)
+		} else {
+			e.w.WriteByte(0)
+		}
+	}
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.w.WriteByte(tagInteger)
+		e.varint(obj.Value)
+	case *object.String:
+		e.w.WriteByte(tagString)
+		e.string(obj.Value)
+	case *object.Float:
+		e.w.WriteByte(tagFloat)
+		binary.Write(e.w, binary.BigEndian, math.Float64bits(obj.Value))
+	case *object.CompiledFunction:
+		e.w.WriteByte(tagCompiledFunction)
+		e.uvarint(uint64(obj.NumLocals))
+		e.uvarint(uint64(obj.NumParameters))
+		e.string(obj.Name)
+		e.instructions(obj.Instructions, obj.SourceMap)
+		e.handlers(obj.Handlers)
+		if e.debug {
+			e.uvarint(uint64(len(obj.FreeNames)))
+			for _, name := range obj.FreeNames {
+				e.string(name)
+			}
+		}
+	default:
+		return fmt.Errorf("cannot encode constant of type %s", obj.Type())
+	}
+	return nil
+}
+
+type decoder struct {
+	r         *bufio.Reader
+	debug     bool
+	filenames []string
+	err       error // the first error, reading stops once it is set
+}
+
+func (d *decoder) fail(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: unexpected end of file", ErrNotBytecode)
+	}
+	return err
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.err = err
+	}
+	return x
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	x, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.err = err
+	}
+	return x
+}
+
+// length reads a length or count. Corrupt files may hold huge ones, so
+// nothing is allocated up front from a count, the values are appended as
+// they are read and run into the end of the file instead.
+func (d *decoder) length() int {
+	n := d.uvarint()
+	if n > 1<<30 {
+		if d.err == nil {
+			d.err = fmt.Errorf("%w: invalid length %d", ErrNotBytecode, n)
+		}
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.length()
+	if d.err != nil {
+		return nil
+	}
+	// read as much as there is instead of allocating n bytes up front
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, d.r, int64(n)); err != nil {
+		d.err = err
+	}
+	return append([]byte{}, buf.Bytes()...)
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) instructions() (code.Instructions, code.SourceMap) {
+	ins := code.Instructions(d.bytes())
+	if !d.debug {
+		return ins, nil
+	}
+	return ins, d.sourceMap()
+}
+
+func (d *decoder) sourceMap() code.SourceMap {
+	count := d.length()
+	m := code.SourceMap{}
+
+	offset := 0
+	for i := 0; i < count && d.err == nil; i++ {
+		offset += int(d.uvarint())
+		pos := token.Position{
+			Offset: int(d.uvarint()),
+			Line:   int(d.uvarint()),
+			Column: int(d.uvarint()),
+		}
+
+		index := d.length()
+		switch {
+		case index < len(d.filenames):
+			pos.Filename = d.filenames[index]
+		case index == len(d.filenames):
+			d.filenames = append(d.filenames, d.string())
+			pos.Filename = d.filenames[index]
+		default:
+			if d.err == nil {
+				d.err = fmt.Errorf("%w: invalid filename index %d", ErrNotBytecode, index)
+			}
+		}
+
+		m = append(m, code.SourceMapEntry{Offset: offset, Pos: pos})
+	}
+
+	return m
+}
+
+func (d *decoder) handlers() code.Handlers {
+	count := d.length()
+	if count == 0 {
+		return nil
+	}
+
+	hs := code.Handlers{}
+	for i := 0; i < count && d.err == nil; i++ {
+		h := code.Handler{
+			Start:  d.length(),
+			End:    d.length(),
+			Target: d.length(),
+			Depth:  d.length(),
+		}
+		if d.err != nil {
+			break
+		}
+
+		flag, err := d.r.ReadByte()
+		if err != nil {
+			d.err = err
+		}
+		h.Finally = flag == 1
+		hs = append(hs, h)
+	}
+	return hs
+}
+
+func (d *decoder) constant() object.Object {
+	tag, err := d.r.ReadByte()
+	if err != nil {
+		d.err = err
+		return nil
+	}
+
+	switch tag {
+	case tagInteger:
+		return &object.Integer{Value: d.varint()}
+	case tagString:
+		return &object.String{Value: d.string()}
+	case tagFloat:
+		var bits uint64
+		if err := binary.Read(d.r, binary.BigEndian, &bits); err != nil {
+			d.err = err
+		}
+		return &object.Float{Value: math.Float64frombits(bits)}
+	case tagCompiledFunction:
+		fn := &object.CompiledFunction{
+			NumLocals:     d.length(),
+			NumParameters: d.length(),
+			Name:          d.string(),
+		}
+		fn.Instructions, fn.SourceMap = d.instructions()
+		fn.Handlers = d.handlers()
+		if d.debug {
+			count := d.length()
+			fn.FreeNames = []string{}
+			for i := 0; i < count && d.err == nil; i++ {
+				fn.FreeNames = append(fn.FreeNames, d.string())
+			}
+		}
+		return fn
+	default:
+		d.err = fmt.Errorf("%w: unknown constant tag %d", ErrNotBytecode, tag)
+		return nil
+	}
+}
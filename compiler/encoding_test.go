@@ -0,0 +1,104 @@
+package compiler
+
+import (
+	"bytes"
+	"errors"
+	"monkey/object"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	input := `
+	let greeting = "hello";
+	let add = fn(a, b) { let c = a + b; c };
+	let adder = fn(x) { fn(y) { add(x, y) } };
+	adder(-3)(123456789012) * 0.5;
+	let safe = fn(f) { try { f() } catch (e) { e } finally { puts("done") } };
+	try { safe(fn() { throw 1 }) } finally { 2 }
+	`
+
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.ByteCode()
+
+	for _, original := range []*Bytecode{bytecode, bytecode.StripDebugInfo()} {
+		var buf bytes.Buffer
+		if err := original.Encode(&buf); err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+
+		if !IsBytecode(buf.Bytes()) {
+			t.Errorf("encoded bytecode is not recognized")
+		}
+
+		decoded, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+
+		if !reflect.DeepEqual(decoded, original) {
+			t.Errorf("decoded bytecode differs.\nwant=%+v\ngot=%+v", original, decoded)
+		}
+	}
+
+	if bytecode.StripDebugInfo().SourceMap != nil {
+		t.Errorf("source map was not stripped")
+	}
+	fn := bytecode.Constants[1].(*object.CompiledFunction)
+	if fn.SourceMap == nil {
+		t.Errorf("stripping debug info modified the original function")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var valid bytes.Buffer
+	if err := (&Bytecode{}).Encode(&valid); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	header := func(version int) string {
+		return BytecodeMagic + string([]byte{byte(version >> 8), byte(version)})
+	}
+
+	tests := []struct {
+		input    []byte
+		expected error
+	}{
+		{[]byte("#!/usr/bin/env monkey"), ErrNotBytecode},
+		{[]byte(header(BytecodeVersion+1) + "\x00\x00\x00"), ErrBytecodeVersion},
+		{[]byte(header(BytecodeVersion) + "\x00\x05\x01"), ErrNotBytecode},
+		{[]byte(header(BytecodeVersion) + "\x00\x00\x01\x09"), ErrNotBytecode},
+		{[]byte(header(BytecodeVersion) + "\x00\x80\x80\x80\x80\x04"), ErrNotBytecode},
+		{[]byte(header(BytecodeVersion) + "\x00\x00\x80\x80\x80\x80\x04"), ErrNotBytecode},
+		{valid.Bytes()[:len(valid.Bytes())-1], ErrNotBytecode},
+		{append(valid.Bytes(), 0), ErrNotBytecode},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.input))
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("input %q: wrong error. want=%v, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+}
+
+func TestEncodeQuote(t *testing.T) {
+	compiler := New()
+	if err := compiler.Compile(parse("let q = quote(1 + 2); q")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var buf bytes.Buffer
+	err := compiler.ByteCode().Encode(&buf)
+	expected := "1:15: code using quote can't be saved as bytecode"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("encoded %d bytes despite the error", buf.Len())
+	}
+}
@@ -0,0 +1,288 @@
+package compiler
+
+import (
+	"fmt"
+	"math"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/token"
+)
+
+// SetOptimize turns the optimizations of the compiler on or off. Optimized
+// code behaves the same, but does less work at runtime:
+//
+//   - infix and prefix expressions on literals are folded into a literal,
+//     `1 + 2 * 3` compiles to a single constant
+//   - if expressions with a literal condition only emit the branch that
+//     is taken, without any jumps. The other one is still compiled, so it
+//     defines the same names and reports the same errors
+//   - double negations of expressions that already are booleans, like
+//     `!!(a > b)`, are dropped
+//
+// Operations that would fail or behave differently at runtime, like a
+// division by zero or comparing strings, are never folded.
+func (c *Compiler) SetOptimize(optimize bool) {
+	c.optimize = optimize
+}
+
+// compileConstantIf compiles the branch of an if expression whose condition
+// is known to be truthy or not
+func (c *Compiler) compileConstantIf(node *ast.IfExpression, truthy bool) error {
+	block, dead := node.Consequence, node.Alternative
+	if !truthy {
+		block, dead = dead, block
+	}
+	if dead != nil {
+		if err := c.compileDead(dead); err != nil {
+			return err
+		}
+	}
+	if block == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	start := len(c.currentInstructions())
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+
+	// the instruction before an empty block isn't ours to remove
+	if len(c.currentInstructions()) == start {
+		c.emit(code.OpNull)
+		return nil
+	}
+	c.keepBlockValue()
+	return nil
+}
+
+// compileDead compiles a block that never runs and throws its code away.
+// The names it defines stay in the symbol table, just like they do when
+// the block is compiled behind a jump.
+func (c *Compiler) compileDead(block *ast.BlockStatement) error {
+	saved, pos := c.scopes[c.scopeIndex], c.pos
+	constants := len(c.constants)
+
+	// the dead code must not back-patch or protect any of the real code
+	scope := &c.scopes[c.scopeIndex]
+	scope.instructions = code.Instructions{}
+	scope.sourceMap = nil
+	scope.handlers = nil
+	scope.loops = make([]*loop, len(saved.loops))
+	for i, l := range saved.loops {
+		scope.loops[i] = &loop{start: l.start, held: l.held, keep: l.keep}
+	}
+	scope.tries = make([]*tryBlock, len(saved.tries))
+	for i, t := range saved.tries {
+		copied := *t
+		copied.protected = append([]code.Handler(nil), t.protected...)
+		scope.tries[i] = &copied
+	}
+
+	err := c.Compile(block)
+
+	c.scopes[c.scopeIndex], c.pos = saved, pos
+	c.constants = c.constants[:constants]
+	return err
+}
+
+// fold returns the literal an expression evaluates to or, if it can't be
+// evaluated at compile time, the expression with its operands folded
+func fold(node ast.Expression) ast.Expression {
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		left, right := fold(node.Left), fold(node.Right)
+		if folded := foldInfix(node, left, right); folded != nil {
+			return folded
+		}
+		if left == node.Left && right == node.Right {
+			return node
+		}
+		return &ast.InfixExpression{
+			Token:    node.Token,
+			Left:     left,
+			Operator: node.Operator,
+			Right:    right,
+		}
+
+	case *ast.PrefixExpression:
+		right := fold(node.Right)
+		if folded := foldPrefix(node, right); folded != nil {
+			return folded
+		}
+		if right == node.Right {
+			return node
+		}
+		return &ast.PrefixExpression{Token: node.Token, Operator: node.Operator, Right: right}
+	}
+
+	return node
+}
+
+func foldInfix(node *ast.InfixExpression, left, right ast.Expression) ast.Expression {
+	pos := node.Pos()
+
+	if node.Operator == "&&" || node.Operator == "||" {
+		return foldLogical(node, left, right)
+	}
+
+	switch left := left.(type) {
+	case *ast.IntegerLiteral:
+		right, ok := right.(*ast.IntegerLiteral)
+		if !ok {
+			return nil
+		}
+		a, b := left.Value, right.Value
+
+		switch node.Operator {
+		case "+":
+			return integerLiteral(a+b, pos)
+		case "-":
+			return integerLiteral(a-b, pos)
+		case "*":
+			return integerLiteral(a*b, pos)
+		case "/":
+			// division by zero and overflow are runtime errors
+			if b == 0 || a == math.MinInt64 && b == -1 {
+				return nil
+			}
+			return integerLiteral(a/b, pos)
+		case "%":
+			if b == 0 {
+				return nil
+			}
+			return integerLiteral(a%b, pos)
+		case "<":
+			return booleanLiteral(a < b, pos)
+		case ">":
+			return booleanLiteral(a > b, pos)
+		case "<=":
+			return booleanLiteral(a <= b, pos)
+		case ">=":
+			return booleanLiteral(a >= b, pos)
+		case "==":
+			return booleanLiteral(a == b, pos)
+		case "!=":
+			return booleanLiteral(a != b, pos)
+		}
+
+	case *ast.StringLiteral:
+		// strings are compared by identity, only concatenation is folded
+		right, ok := right.(*ast.StringLiteral)
+		if ok && node.Operator == "+" {
+			return &ast.StringLiteral{
+				Token: token.Token{Type: token.STRING, Literal: left.Value + right.Value, Pos: pos},
+				Value: left.Value + right.Value,
+			}
+		}
+
+	case *ast.Boolean:
+		right, ok := right.(*ast.Boolean)
+		if !ok {
+			return nil
+		}
+
+		switch node.Operator {
+		case "==":
+			return booleanLiteral(left.Value == right.Value, pos)
+		case "!=":
+			return booleanLiteral(left.Value != right.Value, pos)
+		}
+	}
+
+	return nil
+}
+
+// foldLogical folds && and || if the left operand is a literal, the right
+// operand is dropped if it would never run
+func foldLogical(node *ast.InfixExpression, left, right ast.Expression) ast.Expression {
+	truthy, ok := isTruthy(left)
+	if !ok {
+		return nil
+	}
+
+	pos := node.Pos()
+	if node.Operator == "&&" && !truthy {
+		return booleanLiteral(false, pos)
+	}
+	if node.Operator == "||" && truthy {
+		return booleanLiteral(true, pos)
+	}
+
+	// the result is the truthiness of the right operand
+	bang := token.Token{Type: token.BANG, Literal: "!", Pos: pos}
+	return fold(&ast.PrefixExpression{
+		Token:    bang,
+		Operator: "!",
+		Right:    &ast.PrefixExpression{Token: bang, Operator: "!", Right: right},
+	})
+}
+
+func foldPrefix(node *ast.PrefixExpression, right ast.Expression) ast.Expression {
+	pos := node.Pos()
+
+	switch node.Operator {
+	case "-":
+		if right, ok := right.(*ast.IntegerLiteral); ok {
+			return integerLiteral(-right.Value, pos)
+		}
+
+	case "!":
+		switch right := right.(type) {
+		case *ast.Boolean:
+			return booleanLiteral(!right.Value, pos)
+		case *ast.IntegerLiteral, *ast.StringLiteral:
+			// everything but false and null is truthy
+			return booleanLiteral(false, pos)
+		case *ast.PrefixExpression:
+			if right.Operator == "!" && isBoolean(right.Right) {
+				return right.Right
+			}
+		}
+	}
+
+	return nil
+}
+
+// isBoolean reports whether node always evaluates to true or false
+func isBoolean(node ast.Expression) bool {
+	switch node := node.(type) {
+	case *ast.Boolean:
+		return true
+	case *ast.PrefixExpression:
+		return node.Operator == "!"
+	case *ast.InfixExpression:
+		switch node.Operator {
+		case "<", ">", "<=", ">=", "==", "!=", "&&", "||":
+			return true
+		}
+	}
+	return false
+}
+
+// isTruthy reports whether a literal is truthy, ok is false for anything
+// but literals
+func isTruthy(node ast.Expression) (truthy bool, ok bool) {
+	switch node := node.(type) {
+	case *ast.Boolean:
+		return node.Value, true
+	case *ast.IntegerLiteral, *ast.StringLiteral:
+		return true, true
+	}
+	return false, false
+}
+
+func integerLiteral(value int64, pos token.Position) *ast.IntegerLiteral {
+	return &ast.IntegerLiteral{
+		Token: token.Token{Type: token.INT, Literal: fmt.Sprint(value), Pos: pos},
+		Value: value,
+	}
+}
+
+func booleanLiteral(value bool, pos token.Position) *ast.Boolean {
+	tok := token.Token{Type: token.TRUE, Literal: "true", Pos: pos}
+	if !value {
+		tok = token.Token{Type: token.FALSE, Literal: "false", Pos: pos}
+	}
+	return &ast.Boolean{Token: tok, Value: value}
+}
@@ -1,5 +1,10 @@
 package compiler
 
+import (
+	"monkey/object"
+	"sort"
+)
+
 type SymbolScope string
 
 const (
@@ -7,6 +12,9 @@ const (
 	LocalScope   SymbolScope = "LOCAL"
 	BuiltinScope SymbolScope = "BUILTIN"
 	FreeScope    SymbolScope = "FREE"
+	// FunctionScope is the scope of the name a function is bound to, seen
+	// from inside of the function itself
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 // Symbol holds information about it's related identifier
@@ -41,6 +49,16 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	return s
 }
 
+// NewSymbolTableWithBuiltins creates a global symbol table with every
+// builtin of the registry defined at its registry index
+func NewSymbolTableWithBuiltins(builtins *object.BuiltinRegistry) *SymbolTable {
+	s := NewSymbolTable()
+	for i, b := range builtins.All() {
+		s.DefineBuiltin(i, b.Name)
+	}
+	return s
+}
+
 // defineFree defines a free variable on our symbol table
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
@@ -52,6 +70,14 @@ func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	return symbol
 }
 
+// DefineFunctionName defines the name of the function whose body is being
+// compiled, so the function can reference itself before it is bound
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
 // DefineBuiltin defines a builtin function
 func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
@@ -59,6 +85,9 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	return symbol
 }
 
+// Define binds name in this table. Defining a name again reuses its slot,
+// like the evaluator overwrites the binding, so a let inside of a loop
+// updates the variable the loop reads.
 func (s *SymbolTable) Define(name string) Symbol {
 	symbol := Symbol{Name: name, Index: s.numDefinitions, Scope: GlobalScope}
 	if s.Outer == nil {
@@ -67,11 +96,32 @@ func (s *SymbolTable) Define(name string) Symbol {
 		symbol.Scope = LocalScope
 	}
 
+	if existing, ok := s.store[name]; ok && existing.Scope == symbol.Scope {
+		return existing
+	}
+
 	s.store[name] = symbol
 	s.numDefinitions++
 	return symbol
 }
 
+// Symbols returns the symbols defined in this table, without those of the
+// outer tables, ordered by scope and index
+func (s *SymbolTable) Symbols() []Symbol {
+	symbols := make([]Symbol, 0, len(s.store))
+	for _, symbol := range s.store {
+		symbols = append(symbols, symbol)
+	}
+
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].Scope != symbols[j].Scope {
+			return symbols[i].Scope < symbols[j].Scope
+		}
+		return symbols[i].Index < symbols[j].Index
+	})
+	return symbols
+}
+
 // Resolve rescursively resolves variables on arbitraily deeply nested
 // symbol tables
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
@@ -2,6 +2,72 @@ package compiler
 
 import "testing"
 
+func TestRedefine(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("a")
+
+	tests := []struct {
+		table    *SymbolTable
+		expected Symbol
+	}{
+		{global, Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{global, Symbol{Name: "b", Scope: GlobalScope, Index: 1}},
+		{local, Symbol{Name: "a", Scope: LocalScope, Index: 0}},
+		{local, Symbol{Name: "b", Scope: LocalScope, Index: 1}},
+	}
+
+	for _, tt := range tests {
+		result := tt.table.Define(tt.expected.Name)
+		if result != tt.expected {
+			t.Errorf("expected %s to be defined as %+v, got=%+v",
+				tt.expected.Name, tt.expected, result)
+		}
+	}
+
+	if local.numDefinitions != 2 {
+		t.Errorf("wrong number of definitions. want=2, got=%d", local.numDefinitions)
+	}
+}
+
+func TestDefineAndResolveFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+
+	expected := Symbol{Name: "a", Scope: FunctionScope, Index: 0}
+
+	result, ok := global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v",
+			expected.Name, expected, result)
+	}
+}
+
+func TestShadowingFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+	global.Define("a")
+
+	expected := Symbol{Name: "a", Scope: GlobalScope, Index: 0}
+
+	result, ok := global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v",
+			expected.Name, expected, result)
+	}
+}
+
 func TestResolveUnresolvableFree(t *testing.T) {
     global := NewSymbolTable()
     global.Define("a")
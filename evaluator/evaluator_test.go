@@ -7,6 +7,267 @@ import (
 	"testing"
 )
 
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let a = 1; let b = 2; "total: ${a + b}"`, "total: 3"},
+		{`"${"nested ${1 + 1}"}!"`, "nested 2!"},
+		{`"${[1, "two"]} ${1.5} ${true} ${if (false) { 1 }}"`, "[1, two] 1.5 true null"},
+		{`"cost: \$${2}"`, "cost: $2"},
+		{`let f = fn(n) { "${n}" }; f(7) + "!"`, "7!"},
+		{`"${1 / 0}"`, "ERROR: 1:4: division by zero"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("input %q: wrong result. want=%q, got=%q",
+				tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`try { throw "boom" } catch (e) { e }`, "boom"},
+		{"try { 1 / 0 } catch (e) { e }", "division by zero"},
+		{"try { len(1) } catch (e) { e }", "argument to `len` not supported, got INTEGER"},
+		{"try { 1 } catch (e) { 2 }", "1"},
+		{"try { } catch (e) { 2 }", "null"},
+		{"let f = fn() { throw [1, 2] }; try { f() } catch (e) { e[1] }", "2"},
+		{"let x = 0; try { x = 1 } finally { x = x + 1 }; x", "2"},
+		{"let x = 0; try { try { throw 1 } finally { x = 5 } } catch (e) { x + e }", "6"},
+		{"try { throw 1 } catch (e) { throw e + 1 }", "ERROR: 1:29: 2"},
+		{"let f = fn() { try { return 1 } finally { return 2 } }; f()", "2"},
+		{"let f = fn() { try { throw 1 } finally { return 2 } }; f()", "2"},
+		{"let f = fn() { try { return 1 } finally { 2 } }; f()", "1"},
+		{"let n = 0; for (i in [1, 2, 3]) { try { if (i == 2) { break } } finally { n = n + i } }; n", "3"},
+		{"throw 5", "ERROR: 1:1: 5"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("input %q: wrong result. want=%q, got=%q",
+				tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1; x = x + 1; x", "2"},
+		{"let x = 1; let y = 2; x = y = 3; x + y", "6"},
+		{"let x = 1; x = 5", "5"},
+		{"let x = 1; let f = fn() { x = 10 }; f(); x", "10"},
+		{"let f = fn(x) { x = x * 2; x }; f(4)", "8"},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1 } }; let c = counter(); c(); c()", "2"},
+		{"let n = 0; for (x in [1, 2, 3]) { n = n + x }; n", "6"},
+		{"let a = [1, 2, 3]; a[1] = 5; a", "[1, 5, 3]"},
+		{"let a = [1, 2]; let b = a; b[0] = 3; a[0]", "3"},
+		{`let h = {"a": 1}; h["a"] = 2; h["b"] = 3; h["a"] + h["b"]`, "5"},
+		{`let h = {"a": [1]}; h["a"][0] = 2; h["a"]`, "[2]"},
+		{"x = 1", "ERROR: 1:1: identifier not found: x"},
+		{"len = 1", "ERROR: 1:1: cannot assign to builtin len"},
+		{"let a = [1]; a[1] = 2", "ERROR: 1:14: index out of range: 1"},
+		{`let s = "abc"; s[0] = "x"`, "ERROR: 1:16: index assignment not supported: STRING"},
+		{"let h = {}; h[fn() {}] = 1", "ERROR: 1:13: unusable as hash key: FUNCTION"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("input %q: wrong result. want=%q, got=%q",
+				tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let sum = 0; let i = 0; while (i < 5) { let i = i + 1; let sum = sum + i; }; sum", "15"},
+		{"while (false) { 1 }", "null"},
+		{"let n = 0; for (x in [1, 2, 3]) { let n = n + x; }; n", "6"},
+		{`let s = ""; for (c in "héllo") { let s = c + s; }; s`, "olléh"},
+		{`let ks = []; for (k in {"b": 1, "a": 2, 3: 3}) { let ks = push(ks, k); }; ks`, `[3, a, b]`},
+		{"let n = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } let n = n + x; }; n", "3"},
+		{"let n = 0; for (x in [1, 2, 3, 4]) { if (x % 2 == 0) { continue; } let n = n + x; }; n", "4"},
+		{"let n = 0; while (true) { let n = n + 1; if (n > 2) { break } }; n", "3"},
+		{"let n = 0; for (x in [1, 2]) { for (y in [1, 2, 3]) { if (y == 2) { break } let n = n + 1; } }; n", "2"},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()", "20"},
+		{"let n = 0; for (x in [1, 2, 3]) { let n = n + if (x == 2) { continue; } else { x }; }; n", "4"},
+		{"for (x in 5) { x }", "ERROR: 1:11: cannot iterate over INTEGER"},
+		{"for (x in [1]) { x + true }", "ERROR: 1:18: type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("input %q: wrong result. want=%q, got=%q",
+				tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"monkey"[0]`, "m"},
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[4]`, "o"},
+		{`"héllo"[5]`, "null"},
+		{`"héllo"[-1]`, "null"},
+		{`len("a\tb\n")`, "4"},
+		{`let s = "日本語"; s[len(s) - 1]`, "語"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("input %q: wrong result. want=%q, got=%q",
+				tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestLogicalExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"7 % 3", "1"},
+		{"-7 % 3", "-1"},
+		{"7.5 % 2", "1.5"},
+		{"1 <= 1", "true"},
+		{"2 <= 1", "false"},
+		{"1 >= 1.5", "false"},
+		{"2.5 >= 1", "true"},
+		{"true && 1", "true"},
+		{"1 && false", "false"},
+		{"if (false) { 1 } && 1", "false"},
+		{"false || 0", "true"},
+		{"false || false", "false"},
+		{"1 > 2 || 2 > 1 && 3 > 2", "true"},
+		{"false && undefinedVariable", "false"},
+		{"true || undefinedVariable", "true"},
+		{"false || undefinedVariable", "ERROR: 1:10: identifier not found: undefinedVariable"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("input %q: wrong result. want=%q, got=%q",
+				tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestFloatExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1.5", "1.5"},
+		{"-2.25", "-2.25"},
+		{"0.5 + 0.25", "0.75"},
+		{"3 * 0.5", "1.5"},
+		{"1.5 * 2", "3.0"},
+		{"7 / 2", "3"},
+		{"7 / 2.0", "3.5"},
+		{"1.5 > 1", "true"},
+		{"1 < 1.5", "true"},
+		{"2 == 2.0", "true"},
+		{"2.5 != 2.5", "false"},
+		{`{1: "int", 2.5: "float"}[1.0]`, "int"},
+		{`{1: "int", 2.5: "float"}[2.5]`, "float"},
+		{"let ratio = fn(a, b) { a / (b * 1.0) }; ratio(1, 4) * 100", "25.0"},
+		{`1.5 + "x"`, "ERROR: 1:1: type mismatch: FLOAT + STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("input %q: wrong result. want=%q, got=%q",
+				tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	input := `let inner = fn(x) {
+  x + true
+};
+let outer = fn() {
+  fn() { inner(1) }()
+};
+outer();`
+
+	evaluated := testEval(input)
+	errorObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	expected := `  at inner (2:3)
+  at <anonymous> (5:10)
+  at outer (5:3)
+  at <main> (7:1)
+`
+	if errorObj.Stack.String() != expected {
+		t.Errorf("wrong stack trace. expected=\n%s\ngot=\n%s",
+			expected, errorObj.Stack.String())
+	}
+
+	callSite := errorObj.Stack[0].CallSite
+	if callSite.Line != 5 || callSite.Column != 10 {
+		t.Errorf("wrong call site of inner. got=%s", callSite)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedLine   int
+		expectedColumn int
+	}{
+		{"5 + true;", 1, 1},
+		{"let x = 1;\n  foobar", 2, 3},
+		{"let f = fn(x) {\n  x + true\n};\nf(1)", 2, 3},
+		{`len(1, 2)`, 1, 1},
+		{`[1, -true]`, 1, 5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errorObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)",
+				evaluated, evaluated)
+			continue
+		}
+
+		pos := errorObj.Pos
+		if pos.Line != tt.expectedLine || pos.Column != tt.expectedColumn {
+			t.Errorf("wrong error position for %q. expected=%d:%d, got=%d:%d",
+				tt.input, tt.expectedLine, tt.expectedColumn, pos.Line, pos.Column)
+		}
+	}
+}
+
 func TestHashIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -205,6 +466,8 @@ func TestBuiltInFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("héllo wörld")`, 11},
+		{`len("😀")`, 1},
 		{`len(1)`, "argument to `len` not supported, got INTEGER"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
 	}
@@ -231,7 +494,7 @@ func TestBuiltInFunctions(t *testing.T) {
 }
 
 func TestStringConcatenation(t *testing.T) {
-	input := `"Hello" + " " + "World!`
+	input := `"Hello" + " " + "World!"`
 
 	evaluated := testEval(input)
 	str, ok := evaluated.(*object.String)
@@ -385,6 +648,42 @@ if (10 > 1) {
 			`"Hello" - "World"`,
 			"unknown operator: STRING - STRING",
 		},
+		{
+			"10 / (5 - 5)",
+			"division by zero",
+		},
+		{
+			"10 % 0",
+			"division by zero",
+		},
+		{
+			"true && 1 + true",
+			"type mismatch: INTEGER + BOOLEAN",
+		},
+		{
+			"let min = -9223372036854775807 - 1; min / -1",
+			"integer overflow: -9223372036854775808 / -1",
+		},
+		{
+			"let f = fn(x) { x }; f()",
+			"wrong number of arguments: want=1, got=0",
+		},
+		{
+			"fn() { 1 }(2, 3)",
+			"wrong number of arguments: want=0, got=2",
+		},
+		{
+			"let f = fn(n) { f(n + 1) }; f(0)",
+			"stack overflow",
+		},
+		{
+			"let f = fn() { f = 1 }; f(); f",
+			"cannot assign to function f inside of its body",
+		},
+		{
+			"let f = fn() { fn() { f = 1 }() }; f()",
+			"cannot assign to function f inside of its body",
+		},
 	}
 
 	for _, tt := range tests {
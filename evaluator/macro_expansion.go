@@ -1,14 +1,40 @@
 package evaluator
 
 import (
+	"fmt"
 	"monkey/ast"
 	"monkey/object"
 )
 
+// ExpandProgram defines the macros of program in env and expands every
+// macro call in it. Both backends run it as a pre-pass before executing a
+// program, env keeps the macros for programs expanded later on.
+func ExpandProgram(program *ast.Program, env *object.Environment) (*ast.Program, error) {
+	DefineMacros(program, env)
+
+	expanded, err := expandMacros(program, env)
+	if err != nil {
+		return nil, err
+	}
+	return expanded.(*ast.Program), nil
+}
+
+// ExpandMacros replaces the macro calls in program with the code they
+// return. It panics if a macro doesn't return quoted code.
 func ExpandMacros(program ast.Node, env *object.Environment) ast.Node {
-	return ast.Modify(program, func(node ast.Node) ast.Node {
+	expanded, err := expandMacros(program, env)
+	if err != nil {
+		panic(err.Error())
+	}
+	return expanded
+}
+
+func expandMacros(program ast.Node, env *object.Environment) (ast.Node, error) {
+	var err error
+
+	expanded := ast.Modify(program, func(node ast.Node) ast.Node {
 		callExpression, ok := node.(*ast.CallExpression)
-		if !ok {
+		if !ok || err != nil {
 			return node
 		}
 
@@ -18,17 +44,47 @@ func ExpandMacros(program ast.Node, env *object.Environment) ast.Node {
 		}
 
 		args := quoteArgs(callExpression)
-		evalEnv := extendMacroEnv(macro, args)
+		if len(args) != len(macro.Parameters) {
+			err = fmt.Errorf("%s: wrong number of arguments to macro %s: want=%d, got=%d",
+				node.Pos(), callExpression.Function, len(macro.Parameters), len(args))
+			return node
+		}
+		evaluated := applyMacro(macro, args)
+
+		switch evaluated := evaluated.(type) {
+		case *object.Quote:
+			return evaluated.Node
+		case *object.Error:
+			err = fmt.Errorf("%s: expanding macro %s: %s",
+				node.Pos(), callExpression.Function, evaluated.Message)
+		default:
+			err = fmt.Errorf("%s: macro %s must return quoted code, got %s",
+				node.Pos(), callExpression.Function, typeOf(evaluated))
+		}
+		return node
+	})
 
-		evaluated := Eval(macro.Body, evalEnv)
+	return expanded, err
+}
 
-		quote, ok := evaluated.(*object.Quote)
-		if !ok {
-			panic("we only support returning AST-nodes from macros")
+// applyMacro evaluates the body of macro. Expansion runs before the program
+// does, a panic while expanding is turned into an error just like one while
+// evaluating the program.
+func applyMacro(macro *object.Macro, args []*object.Quote) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
 		}
+	}()
 
-		return quote.Node
-	})
+	return Eval(macro.Body, extendMacroEnv(macro, args))
+}
+
+func typeOf(obj object.Object) object.ObjectType {
+	if obj == nil {
+		return object.NULL_OBJ
+	}
+	return obj.Type()
 }
 
 func isMacroCall(
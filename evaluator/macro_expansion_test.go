@@ -8,6 +8,50 @@ import (
 	"testing"
 )
 
+func TestExpandProgramErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"let m = macro(x) { 1 }; m(2)",
+			"1:25: macro m must return quoted code, got INTEGER",
+		},
+		{
+			"let m = macro(x) { x + 1 }; m(2)",
+			"1:29: expanding macro m: type mismatch: QUOTE + INTEGER",
+		},
+		{
+			"let m = macro(x, y) { x }; m(2)",
+			"1:28: wrong number of arguments to macro m: want=2, got=1",
+		},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+		_, err := ExpandProgram(program, object.NewEnvironment())
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestExpandProgramRecovers(t *testing.T) {
+	builtins := object.NewBuiltinRegistry()
+	builtins.Register("boom", 0, "panics", func(args ...object.Object) object.Object {
+		panic("boom")
+	})
+	env := object.NewEnvironmentWithBuiltins(builtins)
+
+	program := testParseProgram("let m = macro() { boom() }; m()")
+	_, err := ExpandProgram(program, env)
+
+	expected := "1:29: expanding macro m: internal error: boom"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. expected=%q, got=%v", expected, err)
+	}
+}
+
 func TestExpandMacros(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -1,48 +1,92 @@
 package lexer
 
-import "monkey/token"
+import (
+	"fmt"
+	"monkey/token"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
 
+// Lexer splits Monkey source code into tokens. The input is read as UTF-8,
+// identifiers may contain any Unicode letter.
 type Lexer struct {
 	input        string
 	position     int  // current pos in input (points to curr char)
 	readPosition int  // current reading pos in input (after current char)
-	ch           byte // current char under examination
+	ch           rune // current char under examination
+
+	filename string
+	line     int // line of the current char
+	column   int // column of the current char
+
+	keepComments bool
+
+	// the ${} expressions embedded in strings we're in, innermost last,
+	// with the number of braces opened inside of them
+	interpolations []int
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFilename(input, "")
+}
+
+// NewWithFilename creates a lexer whose token positions refer to the
+// given file name. Used when lexing scripts loaded from disk.
+func NewWithFilename(input string, filename string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.readChar()
 	return l
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
+// SetKeepComments makes NextToken return comments as COMMENT tokens instead
+// of skipping them, for tools like formatters that need to preserve them.
+// The parser expects comments to be skipped.
+func (l *Lexer) SetKeepComments(keep bool) {
+	l.keepComments = keep
+}
+
+// NextToken lexes the next token in the input and records its start and
+// end position
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+	for {
+		l.skipWhitespace()
 
-	l.skipWhitespace()
+		pos := l.currentPosition()
+		isComment := l.ch == '/' && (l.peekChar() == '/' || l.peekChar() == '*')
 
-	switch l.ch {
-	case '=':
-		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.EQ, Literal: literal}
+		var tok token.Token
+		if isComment {
+			tok = l.readComment()
 		} else {
-			tok = newToken(token.ASSIGN, l.ch)
+			tok = l.readToken()
 		}
-	case '!':
-		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.NOT_EQ, Literal: literal}
-		} else {
-			tok = newToken(token.BANG, l.ch)
+		tok.Pos = pos
+		tok.End = l.currentPosition()
+
+		if tok.Type == token.COMMENT && !l.keepComments {
+			continue
 		}
+		return tok
+	}
+}
+
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
+
+	switch l.ch {
+	case '=':
+		tok = l.readOperator('=', token.EQ, token.ASSIGN)
+	case '!':
+		tok = l.readOperator('=', token.NOT_EQ, token.BANG)
+	case '&':
+		tok = l.readOperator('&', token.AND, token.ILLEGAL)
+	case '|':
+		tok = l.readOperator('|', token.OR, token.ILLEGAL)
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case '(':
@@ -54,8 +98,19 @@ func (l *Lexer) NextToken() token.Token {
 	case '+':
 		tok = newToken(token.PLUS, l.ch)
 	case '{':
+		if n := len(l.interpolations); n > 0 {
+			l.interpolations[n-1]++
+		}
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
+		n := len(l.interpolations)
+		if n > 0 && l.interpolations[n-1] == 0 {
+			// the end of an embedded expression, the string goes on
+			return l.readString()
+		}
+		if n > 0 {
+			l.interpolations[n-1]--
+		}
 		tok = newToken(token.RBRACE, l.ch)
 	case '-':
 		tok = newToken(token.MINUS, l.ch)
@@ -63,10 +118,12 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.SLASH, l.ch)
 	case '*':
 		tok = newToken(token.ASTERISK, l.ch)
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		tok = l.readOperator('=', token.LT_EQ, token.LT)
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		tok = l.readOperator('=', token.GT_EQ, token.GT)
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
@@ -74,8 +131,7 @@ func (l *Lexer) NextToken() token.Token {
 	case ':':
 		tok = newToken(token.COLON, l.ch)
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		return l.readString()
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -85,8 +141,7 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.LookupIdent(tok.Literal)
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Literal, tok.Type = l.readNumber()
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
@@ -96,22 +151,50 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
+// readOperator returns a token of type double if the char under examination
+// is followed by next, or a token of type single for the char alone
+func (l *Lexer) readOperator(next rune, double, single token.TokenType) token.Token {
+	if l.peekChar() != next {
+		return newToken(single, l.ch)
+	}
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: double, Literal: string(ch) + string(l.ch)}
+}
+
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
+	width := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.position = l.readPosition
-	l.readPosition += 1
+	l.readPosition += width
+}
+
+// currentPosition returns the position of the char under examination
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
 }
 
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
-	} else {
-		return l.input[l.readPosition]
 	}
+	ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	return ch
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -123,19 +206,30 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer or, if the digits are followed by a dot and
+// more digits, a float
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
+	if l.ch != '.' || !isDigit(l.peekChar()) {
+		return l.input[position:l.position], token.INT
+	}
+
+	l.readChar()
+	for isDigit(l.ch) {
+		l.readChar()
+	}
+	return l.input[position:l.position], token.FLOAT
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
@@ -145,13 +239,138 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-func (l *Lexer) readString() string {
-	position := l.position + 1
+// readComment reads a // comment up to the end of the line or a /* */
+// comment, including the delimiters. Block comments that aren't closed are
+// returned as ILLEGAL.
+func (l *Lexer) readComment() token.Token {
+	position := l.position
+
+	if l.peekChar() == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		return token.Token{Type: token.COMMENT, Literal: l.input[position:l.position]}
+	}
+
+	l.readChar()
+	l.readChar()
+	for !(l.ch == '*' && l.peekChar() == '/') {
+		if l.ch == 0 {
+			return token.Token{Type: token.ILLEGAL, Literal: l.input[position:l.position]}
+		}
+		l.readChar()
+	}
+	l.readChar()
+	l.readChar()
+	return token.Token{Type: token.COMMENT, Literal: l.input[position:l.position]}
+}
+
+// readString reads a string literal and decodes its escape sequences.
+// Strings with embedded ${} expressions are read a part at a time, the
+// tokens of the expressions are returned in between. Strings that aren't
+// terminated or contain invalid escape sequences are returned as ILLEGAL
+// tokens holding the source of the literal, or of the part, in double
+// quotes. Unquote tells what's wrong with them.
+func (l *Lexer) readString() token.Token {
+	position := l.position
+	opening := l.ch == '"'
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
+		if l.ch == '\\' {
+			l.readChar()
+		} else if l.ch == '"' || l.ch == '$' && l.peekChar() == '{' {
 			break
 		}
+		if l.ch == 0 {
+			return token.Token{Type: token.ILLEGAL, Literal: `"` + l.input[position+1:l.position]}
+		}
 	}
-	return l.input[position:l.position]
+
+	literal := `"` + l.input[position+1:l.position] + `"`
+	var tok token.Token
+	switch {
+	case l.ch == '"' && opening:
+		tok.Type = token.STRING
+	case l.ch == '"':
+		tok.Type = token.STRING_END
+		l.interpolations = l.interpolations[:len(l.interpolations)-1]
+	case opening:
+		tok.Type = token.STRING_START
+		l.interpolations = append(l.interpolations, 0)
+		l.readChar()
+	default:
+		tok.Type = token.STRING_MIDDLE
+		l.readChar()
+	}
+	l.readChar()
+
+	value, err := Unquote(literal)
+	if err != nil {
+		return token.Token{Type: token.ILLEGAL, Literal: literal}
+	}
+	tok.Literal = value
+	return tok
+}
+
+// Unquote returns the value of a double quoted Monkey string literal. The
+// escape sequences \n, \r, \t, \\, \" and \$ are supported, as well as \uXXXX
+// and \UXXXXXXXX for Unicode code points in hexadecimal. Escaping the $
+// keeps the lexer from treating ${ as the start of an embedded expression.
+func Unquote(literal string) (string, error) {
+	if len(literal) == 0 || literal[0] != '"' {
+		return "", fmt.Errorf("string literal must be quoted")
+	}
+
+	var value []byte
+	for i := 1; i < len(literal); {
+		ch := literal[i]
+		switch {
+		case ch == '"' && i == len(literal)-1:
+			return string(value), nil
+		case ch == '"':
+			return "", fmt.Errorf("unexpected %q after string literal", literal[i+1:])
+		case ch != '\\':
+			value = append(value, ch)
+			i++
+			continue
+		}
+
+		if i+1 >= len(literal)-1 {
+			break
+		}
+		escape := literal[i+1]
+		i += 2
+
+		switch escape {
+		case 'n':
+			value = append(value, '\n')
+		case 'r':
+			value = append(value, '\r')
+		case 't':
+			value = append(value, '\t')
+		case '\\', '"', '$':
+			value = append(value, escape)
+		case 'u', 'U':
+			digits := 4
+			if escape == 'U' {
+				digits = 8
+			}
+			if i+digits > len(literal)-1 {
+				return "", fmt.Errorf("invalid escape sequence \\%c%s: want %d hex digits",
+					escape, literal[i:len(literal)-1], digits)
+			}
+			code, err := strconv.ParseUint(literal[i:i+digits], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", fmt.Errorf("invalid escape sequence \\%c%s",
+					escape, literal[i:i+digits])
+			}
+			value = append(value, string(rune(code))...)
+			i += digits
+		default:
+			r, _ := utf8.DecodeRuneInString(literal[i-1:])
+			return "", fmt.Errorf("invalid escape sequence \\%c", r)
+		}
+	}
+
+	return "", fmt.Errorf("string literal not terminated")
 }
@@ -6,6 +6,276 @@ import (
 	"monkey/token"
 )
 
+func TestInterpolatedStrings(t *testing.T) {
+	input := `"a ${x + {"k": 1}["k"]} b ${"c ${y}"}" "$5 \${z}" "${1}\q"`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING_START, "a "},
+		{token.IDENT, "x"},
+		{token.PLUS, "+"},
+		{token.LBRACE, "{"},
+		{token.STRING, "k"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.STRING, "k"},
+		{token.RBRACKET, "]"},
+		{token.STRING_MIDDLE, " b "},
+		{token.STRING_START, "c "},
+		{token.IDENT, "y"},
+		{token.STRING_END, ""},
+		{token.STRING_END, ""},
+		{token.STRING, "$5 ${z}"},
+		{token.STRING_START, ""},
+		{token.INT, "1"},
+		{token.ILLEGAL, `"\q"`},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	input := `"tab\tnew\nline" "\"quoted\" \\" "caf\u00e9 \U0001F600" "héllo wörld" größe
+"bad \x" "open \"`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedColumn  int
+	}{
+		{token.STRING, "tab\tnew\nline", 1},
+		{token.STRING, `"quoted" \`, 18},
+		{token.STRING, "café 😀", 34},
+		{token.STRING, "héllo wörld", 57},
+		{token.IDENT, "größe", 71},
+		{token.ILLEGAL, `"bad \x"`, 1},
+		{token.ILLEGAL, `"open \"`, 10},
+		{token.EOF, "", 18},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - wrong column. expected=%d, got=%d",
+				i, tt.expectedColumn, tok.Pos.Column)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+let x = 5; // trailing comment
+/* block
+   comment */ x / 2 /**/;
+// comment at the end`
+
+	tests := []struct {
+		keepComments bool
+		expected     []token.Token
+	}{
+		{
+			false,
+			[]token.Token{
+				{Type: token.LET, Literal: "let"},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.ASSIGN, Literal: "="},
+				{Type: token.INT, Literal: "5"},
+				{Type: token.SEMICOLON, Literal: ";"},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.SLASH, Literal: "/"},
+				{Type: token.INT, Literal: "2"},
+				{Type: token.SEMICOLON, Literal: ";"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+		{
+			true,
+			[]token.Token{
+				{Type: token.COMMENT, Literal: "// leading comment"},
+				{Type: token.LET, Literal: "let"},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.ASSIGN, Literal: "="},
+				{Type: token.INT, Literal: "5"},
+				{Type: token.SEMICOLON, Literal: ";"},
+				{Type: token.COMMENT, Literal: "// trailing comment"},
+				{Type: token.COMMENT, Literal: "/* block\n   comment */"},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.SLASH, Literal: "/"},
+				{Type: token.INT, Literal: "2"},
+				{Type: token.COMMENT, Literal: "/**/"},
+				{Type: token.SEMICOLON, Literal: ";"},
+				{Type: token.COMMENT, Literal: "// comment at the end"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		l := New(input)
+		l.SetKeepComments(tt.keepComments)
+
+		for i, expected := range tt.expected {
+			tok := l.NextToken()
+
+			if tok.Type != expected.Type || tok.Literal != expected.Literal {
+				t.Fatalf("keepComments=%t, tests[%d] - wrong token. expected=%q %q, got=%q %q",
+					tt.keepComments, i, expected.Type, expected.Literal, tok.Type, tok.Literal)
+			}
+		}
+	}
+
+	l := New("1 /* never closed\n2")
+	l.NextToken()
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL || tok.Literal != "/* never closed\n2" {
+		t.Errorf("unterminated comment not illegal. got=%q %q", tok.Type, tok.Literal)
+	}
+	if tok.Pos.Column != 3 || tok.End.Line != 2 {
+		t.Errorf("wrong position for unterminated comment. got=%s-%s", tok.Pos, tok.End)
+	}
+}
+
+func TestOperators(t *testing.T) {
+	input := `a <= b >= c % d && e || !f < g = h & i | j`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "d"},
+		{token.AND, "&&"},
+		{token.IDENT, "e"},
+		{token.OR, "||"},
+		{token.BANG, "!"},
+		{token.IDENT, "f"},
+		{token.LT, "<"},
+		{token.IDENT, "g"},
+		{token.ASSIGN, "="},
+		{token.IDENT, "h"},
+		{token.ILLEGAL, "&"},
+		{token.IDENT, "i"},
+		{token.ILLEGAL, "|"},
+		{token.IDENT, "j"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `5 3.14 0.5 10.x 7.`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "0.5"},
+		{token.INT, "10"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "x"},
+		{token.INT, "7"},
+		{token.ILLEGAL, "."},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  x + "ab";`
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedLine   int
+		expectedColumn int
+		expectedEnd    int // column right after the token
+	}{
+		{token.LET, 1, 1, 4},
+		{token.IDENT, 1, 5, 6},
+		{token.ASSIGN, 1, 7, 8},
+		{token.INT, 1, 9, 10},
+		{token.SEMICOLON, 1, 10, 11},
+		{token.IDENT, 2, 3, 4},
+		{token.PLUS, 2, 5, 6},
+		{token.STRING, 2, 7, 11},
+		{token.SEMICOLON, 2, 11, 12},
+		{token.EOF, 2, 12, 13},
+	}
+
+	l := NewWithFilename(input, "test.mk")
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos.Filename != "test.mk" {
+			t.Fatalf("tests[%d] - filename wrong. got=%q", i, tok.Pos.Filename)
+		}
+
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+
+		if tok.End.Column != tt.expectedEnd {
+			t.Fatalf("tests[%d] - end column wrong. expected=%d, got=%d",
+				i, tt.expectedEnd, tok.End.Column)
+		}
+	}
+}
+
 func TestNextToken(t *testing.T) {
 	input := `let five = 5;
 let ten = 10;
@@ -16,7 +286,7 @@ let add = fn(x, y) {
 
 let result = add(five, ten);
 
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
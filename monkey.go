@@ -0,0 +1,334 @@
+// Package monkey embeds the Monkey programming language into Go programs.
+//
+// An Interpreter wires the lexer, parser and one of the two backends
+// together and keeps its state between calls, so globals defined by one
+// piece of code are visible to the next one, just like in the REPL:
+//
+//	interp := monkey.New(monkey.EngineVM)
+//	interp.Set("name", &object.String{Value: "Monkey"})
+//	result, err := interp.Eval(`"Hello " + name`)
+package monkey
+
+import (
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/token"
+	"monkey/vm"
+	"os"
+)
+
+// Engine selects the backend an Interpreter executes code with
+type Engine string
+
+const (
+	// EngineVM compiles code to bytecode and runs it on the virtual machine
+	EngineVM Engine = "vm"
+	// EngineEval runs code with the tree-walking evaluator
+	EngineEval Engine = "eval"
+)
+
+// Interpreter runs Monkey code. It is not safe for concurrent use, but any
+// number of interpreters can be used side by side.
+type Interpreter struct {
+	// Stdout is where the puts builtin writes to, os.Stdout if nil
+	Stdout io.Writer
+	// Stderr is where parser warnings are reported to, os.Stderr if nil
+	Stderr io.Writer
+	// Optimize makes the VM engine compile code with optimizations, see
+	// compiler.Compiler.SetOptimize
+	Optimize bool
+
+	engine   Engine
+	builtins *object.BuiltinRegistry
+
+	// macros defined so far, shared by both engines
+	macroEnv *object.Environment
+
+	// evaluator state
+	env *object.Environment
+
+	// vm state
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+}
+
+// Program is Monkey code that has been parsed and, for the VM engine,
+// compiled to bytecode. It can be run any number of times.
+type Program struct {
+	ast      *ast.Program
+	bytecode *compiler.Bytecode
+}
+
+// Bytecode returns the compiled bytecode, nil for the evaluator engine
+func (p *Program) Bytecode() *compiler.Bytecode {
+	return p.bytecode
+}
+
+// New creates an interpreter that executes code with the given engine
+func New(engine Engine) *Interpreter {
+	i := &Interpreter{engine: engine}
+	i.builtins = object.NewDefaultBuiltins(writerFunc(func(p []byte) (int, error) {
+		return i.stdout().Write(p)
+	}))
+
+	i.macroEnv = object.NewEnvironment()
+	i.env = object.NewEnvironmentWithBuiltins(i.builtins)
+
+	i.symbolTable = compiler.NewSymbolTableWithBuiltins(i.builtins)
+	i.constants = []object.Object{}
+	i.globals = make([]object.Object, vm.GlobalsSize)
+
+	return i
+}
+
+// Engine returns the backend the interpreter runs code with
+func (i *Interpreter) Engine() Engine {
+	return i.engine
+}
+
+// Register exposes the Go function fn to Monkey code run by this
+// interpreter as the builtin name, see object.BuiltinRegistry.Register.
+// Builtins of other interpreters are not affected. Like builtins defined
+// by default, it is shadowed by a global variable of the same name, no
+// matter whether the global was defined before or after.
+func (i *Interpreter) Register(
+	name string,
+	arity int,
+	doc string,
+	fn object.BuiltinFunction,
+) {
+	i.builtins.Register(name, arity, doc, fn)
+	i.defineBuiltin(name)
+}
+
+// RegisterFunc exposes an arbitrary Go function to Monkey code run by this
+// interpreter, converting arguments and results automatically, see
+// object.WrapFunc:
+//
+//	interp.RegisterFunc("split", "split(s, sep) splits s around sep", strings.Split)
+func (i *Interpreter) RegisterFunc(name string, doc string, fn interface{}) error {
+	if _, err := i.builtins.RegisterFunc(name, doc, fn); err != nil {
+		return err
+	}
+	i.defineBuiltin(name)
+	return nil
+}
+
+func (i *Interpreter) defineBuiltin(name string) {
+	// the evaluator looks up globals before builtins
+	if symbol, ok := i.symbolTable.Resolve(name); ok && symbol.Scope == compiler.GlobalScope {
+		return
+	}
+
+	_, index, _ := i.builtins.Lookup(name)
+	i.symbolTable.DefineBuiltin(index, name)
+}
+
+// Builtins returns the registry of builtins available to the interpreter
+func (i *Interpreter) Builtins() *object.BuiltinRegistry {
+	return i.builtins
+}
+
+// SymbolTable returns the global symbol table code compiled by the VM
+// engine is resolved against
+func (i *Interpreter) SymbolTable() *compiler.SymbolTable {
+	return i.symbolTable
+}
+
+// Set defines the global variable name, so code run afterwards can use it
+func (i *Interpreter) Set(name string, value object.Object) {
+	if i.engine == EngineEval {
+		i.env.Set(name, value)
+		return
+	}
+
+	symbol, ok := i.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		symbol = i.symbolTable.Define(name)
+	}
+	i.globals[symbol.Index] = value
+}
+
+// Get returns the value of the global variable name
+func (i *Interpreter) Get(name string) (object.Object, bool) {
+	if i.engine == EngineEval {
+		return i.env.Get(name)
+	}
+
+	symbol, ok := i.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		return nil, false
+	}
+	return i.globals[symbol.Index], i.globals[symbol.Index] != nil
+}
+
+// Compile parses src and, for the VM engine, compiles it to bytecode
+func (i *Interpreter) Compile(src string) (*Program, error) {
+	return i.CompileFile("", src)
+}
+
+// CompileFile is like Compile, positions in errors refer to filename
+func (i *Interpreter) CompileFile(filename string, src string) (*Program, error) {
+	l := lexer.NewWithFilename(src, filename)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		return nil, &SyntaxError{Source: src, Diagnostics: p.Errors()}
+	}
+	parser.RenderDiagnostics(i.stderr(), src, p.Diagnostics())
+
+	program, err := evaluator.ExpandProgram(program, i.macroEnv)
+	if err != nil {
+		return nil, &CompileError{Err: err}
+	}
+
+	if i.engine == EngineEval {
+		return &Program{ast: program}, nil
+	}
+
+	comp := compiler.NewWithState(i.symbolTable, i.constants)
+	comp.SetOptimize(i.Optimize)
+	if err := comp.Compile(program); err != nil {
+		return nil, &CompileError{Err: err}
+	}
+
+	bytecode := comp.ByteCode()
+	i.constants = bytecode.Constants
+
+	return &Program{ast: program, bytecode: bytecode}, nil
+}
+
+// LoadBytecode wraps bytecode compiled earlier, for example decoded from a
+// file with compiler.Decode, so it can be run by the interpreter. The code
+// must have been compiled by an interpreter that defined the same globals
+// and builtins in the same order. Only the VM engine can run bytecode.
+//
+// The bytecode is verified before it is accepted, malformed bytecode is
+// reported as a *vm.VerifyError instead of crashing the VM.
+func (i *Interpreter) LoadBytecode(bytecode *compiler.Bytecode) (*Program, error) {
+	if i.engine != EngineVM {
+		return nil, fmt.Errorf("the %s engine can't run bytecode", i.engine)
+	}
+
+	machine := vm.NewWithBuiltins(bytecode, i.globals, i.builtins)
+	if err := machine.Verify(); err != nil {
+		return nil, err
+	}
+	return &Program{bytecode: bytecode}, nil
+}
+
+// Run executes a program compiled by this interpreter and returns the
+// value of its last expression statement
+func (i *Interpreter) Run(program *Program) (object.Object, error) {
+	if i.engine == EngineEval {
+		result := evaluator.Eval(program.ast, i.env)
+		if errObj, ok := result.(*object.Error); ok {
+			return nil, &RuntimeError{
+				Message: errObj.Message,
+				Pos:     errObj.Pos,
+				Stack:   errObj.Stack,
+			}
+		}
+		if result == nil {
+			return evaluator.NULL, nil
+		}
+		return result, nil
+	}
+
+	if program.bytecode == nil {
+		return nil, fmt.Errorf("program was not compiled for the vm engine")
+	}
+
+	machine := vm.NewWithBuiltins(program.bytecode, i.globals, i.builtins)
+	if err := machine.Run(); err != nil {
+		if vmErr, ok := err.(*vm.RuntimeError); ok {
+			return nil, &RuntimeError{
+				Message: vmErr.Message,
+				Pos:     vmErr.Pos,
+				Stack:   vmErr.Stack,
+			}
+		}
+		return nil, &RuntimeError{Message: err.Error()}
+	}
+
+	return machine.Result(), nil
+}
+
+// Eval compiles and runs src
+func (i *Interpreter) Eval(src string) (object.Object, error) {
+	program, err := i.Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	return i.Run(program)
+}
+
+func (i *Interpreter) stdout() io.Writer {
+	if i.Stdout == nil {
+		return os.Stdout
+	}
+	return i.Stdout
+}
+
+func (i *Interpreter) stderr() io.Writer {
+	if i.Stderr == nil {
+		return os.Stderr
+	}
+	return i.Stderr
+}
+
+// writerFunc adapts a function to the io.Writer interface
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// SyntaxError is returned for source code the parser rejected
+type SyntaxError struct {
+	Source      string
+	Diagnostics []*parser.Diagnostic // the errors, in source order
+}
+
+func (e *SyntaxError) Error() string {
+	msg := e.Diagnostics[0].Error()
+	if len(e.Diagnostics) > 1 {
+		msg += fmt.Sprintf(" (and %d more errors)", len(e.Diagnostics)-1)
+	}
+	return msg
+}
+
+// Render writes the diagnostics along with the offending source lines to w
+func (e *SyntaxError) Render(w io.Writer) {
+	parser.RenderDiagnostics(w, e.Source, e.Diagnostics)
+}
+
+// CompileError is returned when valid source can't be compiled, because
+// expanding its macros failed or, for the VM engine, it can't be compiled
+// to bytecode
+type CompileError struct {
+	Err error
+}
+
+func (e *CompileError) Error() string { return "compile error: " + e.Err.Error() }
+func (e *CompileError) Unwrap() error { return e.Err }
+
+// RuntimeError is returned when a program fails while running
+type RuntimeError struct {
+	Message string
+	Pos     token.Position    // where the error originated, if known
+	Stack   object.StackTrace // the active calls, innermost first
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Pos.IsValid() {
+		return fmt.Sprintf("runtime error: %s: %s", e.Pos, e.Message)
+	}
+	return "runtime error: " + e.Message
+}
@@ -0,0 +1,652 @@
+package monkey
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/object"
+	"monkey/vm"
+	"strings"
+	"testing"
+)
+
+var engines = []Engine{EngineVM, EngineEval}
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	for _, engine := range engines {
+		interp := New(engine)
+
+		_, err := interp.Eval(`
+		let check = fn(x) { if (x > 2) { x + "!" } else { check(x + 1) } };
+		check(0)`)
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("engine %s: expected RuntimeError. got=%T (%v)", engine, err, err)
+		}
+
+		// three recursive calls from x=0 to x=3, plus the main frame
+		if len(runtimeErr.Stack) != 5 {
+			t.Fatalf("engine %s: wrong number of frames. got=%d (%+v)",
+				engine, len(runtimeErr.Stack), runtimeErr.Stack)
+		}
+		for _, frame := range runtimeErr.Stack[:4] {
+			if frame.Function != "check" {
+				t.Errorf("engine %s: wrong function. got=%q", engine, frame.Function)
+			}
+		}
+		if runtimeErr.Stack[4].Function != object.MainFunction {
+			t.Errorf("engine %s: last frame is not main. got=%q",
+				engine, runtimeErr.Stack[4].Function)
+		}
+	}
+}
+
+func TestStackTracesMatchAcrossEngines(t *testing.T) {
+	input := `let inner = fn(x) {
+  x + true
+};
+let outer = fn() {
+  fn() { inner(1) }()
+};
+outer();`
+
+	traces := map[Engine]string{}
+	for _, engine := range engines {
+		_, err := New(engine).Eval(input)
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("engine %s: expected RuntimeError. got=%T (%v)", engine, err, err)
+		}
+		if runtimeErr.Pos.String() != "2:3" {
+			t.Errorf("engine %s: wrong error position. got=%s", engine, runtimeErr.Pos)
+		}
+		traces[engine] = runtimeErr.Stack.String()
+	}
+
+	if traces[EngineVM] != traces[EngineEval] {
+		t.Errorf("stack traces differ. vm=\n%s\neval=\n%s",
+			traces[EngineVM], traces[EngineEval])
+	}
+}
+
+func TestCatchCallErrorsAcrossEngines(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(x) { x }; try { f() } catch (e) { e }", "wrong number of arguments: want=1, got=0"},
+		{"try { fn() { 1 }(2) } catch (e) { e }", "wrong number of arguments: want=0, got=1"},
+		{"let f = fn(n) { f(n + 1) }; try { f(0) } catch (e) { e }", "stack overflow"},
+		{"let f = fn(n) { f(n + 1) }; let x = try { f(0) } catch (e) { 1 }; x + 1", "2"},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range engines {
+			result, err := New(engine).Eval(tt.input)
+			if err != nil {
+				t.Errorf("engine %s, input %q: unexpected error: %v", engine, tt.input, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("engine %s, input %q: wrong result. want=%q, got=%q",
+					engine, tt.input, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestRecursionAfterRebindingAcrossEngines(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; let g = f; let f = fn(n) { 100 }; g(3)", "0"},
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; let g = f; f = fn(n) { 100 }; g(3)", "0"},
+		{"let f = fn(n) { if (n == 0) { 0 } else { fn() { f(n - 1) }() } }; let g = f; f = 1; g(3)", "0"},
+		{"let outer = fn() { let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; let g = f; f = 5; g(2) }; outer()", "0"},
+		{"let f = fn(f) { f }; f(7)", "7"},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range engines {
+			result, err := New(engine).Eval(tt.input)
+			if err != nil {
+				t.Errorf("engine %s, input %q: unexpected error: %v", engine, tt.input, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("engine %s, input %q: wrong result. want=%q, got=%q",
+					engine, tt.input, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestCyclicValuesAcrossEngines(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		output   string
+	}{
+		{"let a = [1]; a[0] = a; puts(a); a", "[[...]]", "[[...]]\n"},
+		{"let a = [1, 2]; a[1] = a; \"${a}\"", "[1, [...]]", ""},
+		{`let h = {"k": 1}; h["k"] = h; h`, "{k: {...}}", ""},
+		{"let a = [0]; let h = {1: a}; a[0] = h; [a, h]", "[[{1: [...]}], {1: [{...}]}]", ""},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range engines {
+			var out bytes.Buffer
+			interp := New(engine)
+			interp.Stdout = &out
+
+			result, err := interp.Eval(tt.input)
+			if err != nil {
+				t.Errorf("engine %s, input %q: unexpected error: %v", engine, tt.input, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("engine %s, input %q: wrong result. want=%q, got=%q",
+					engine, tt.input, tt.expected, result.Inspect())
+			}
+			if out.String() != tt.output {
+				t.Errorf("engine %s, input %q: wrong output. want=%q, got=%q",
+					engine, tt.input, tt.output, out.String())
+			}
+
+			var v interface{}
+			if result.Type() == object.STRING_OBJ {
+				continue
+			}
+			if err := object.ToGo(result, &v); err == nil {
+				t.Errorf("engine %s, input %q: expected error converting to Go", engine, tt.input)
+			}
+		}
+	}
+}
+
+func TestAssignToFunctionNameAcrossEngines(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the result or, if the program fails, its error
+	}{
+		{"let f = fn() { f = 1 }; f(); f", "1:16: cannot assign to function f inside of its body"},
+		{"let f = fn() { fn() { f = 1 }() }; f()", "1:23: cannot assign to function f inside of its body"},
+		{"let f = fn() { let f = 2; f = 3; f }; f()", "3"},
+		{"let f = fn(n) { if (n > 0) { f(n - 1) } else { 1 } }; let g = f; f = 5; g(2) + f", "6"},
+		{"let f = fn() { f }; let g = f; f = 1; g() == g", "true"},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range engines {
+			result, err := New(engine).Eval(tt.input)
+			if err != nil {
+				if !strings.Contains(err.Error(), tt.expected) {
+					t.Errorf("engine %s, input %q: wrong error. want %q in %q",
+						engine, tt.input, tt.expected, err.Error())
+				}
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("engine %s, input %q: wrong result. want=%q, got=%q",
+					engine, tt.input, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestLoopResultsAcrossEngines(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1; for (x in [7, 8]) { x }", "null"},
+		{"let n = 0; while (n < 2) { n = n + 1 }", "null"},
+		{"while (true) { break }", "null"},
+		{"for (x in [1]) { x }; 5", "5"},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range engines {
+			result, err := New(engine).Eval(tt.input)
+			if err != nil {
+				t.Errorf("engine %s, input %q: unexpected error: %v", engine, tt.input, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("engine %s, input %q: wrong result. want=%q, got=%q",
+					engine, tt.input, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestTopLevelReturnAcrossEngines(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		output   string
+	}{
+		{"return 10; 9", "10", ""},
+		{"puts(1); return 2; puts(3)", "2", "1\n"},
+		{"if (true) { return 1 }; 2", "1", ""},
+		{"for (x in [1, 2, 3]) { if (x == 2) { return x * 10 } }; 0", "20", ""},
+		{"try { return 1 } finally { puts(2) }; 3", "1", "2\n"},
+		{"let f = fn() { return 1; 2 }; f() + 1", "2", ""},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range engines {
+			var out bytes.Buffer
+			interp := New(engine)
+			interp.Stdout = &out
+
+			result, err := interp.Eval(tt.input)
+			if err != nil {
+				t.Errorf("engine %s, input %q: unexpected error: %v", engine, tt.input, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("engine %s, input %q: wrong result. want=%q, got=%q",
+					engine, tt.input, tt.expected, result.Inspect())
+			}
+			if out.String() != tt.output {
+				t.Errorf("engine %s, input %q: wrong output. want=%q, got=%q",
+					engine, tt.input, tt.output, out.String())
+			}
+		}
+	}
+}
+
+func TestFloatsReadBackAcrossEngines(t *testing.T) {
+	values := []float64{1.5, 2, -0.25, 1e20, 1e21, 1e-7, 123.456e-15, 1e300, 5e-324}
+
+	for _, value := range values {
+		src := (&object.Float{Value: value}).Inspect()
+		for _, engine := range engines {
+			result, err := New(engine).Eval(src)
+			if err != nil {
+				t.Errorf("engine %s, input %q: unexpected error: %v", engine, src, err)
+				continue
+			}
+			float, ok := result.(*object.Float)
+			if !ok || float.Value != value {
+				t.Errorf("engine %s, input %q: wrong result. want=%v, got=%s",
+					engine, src, value, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestLoadBytecode(t *testing.T) {
+	interp := New(EngineVM)
+	program, err := interp.Compile(`let double = fn(x) { x * 2 }; double(21)`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := program.Bytecode().Encode(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	bytecode, err := compiler.Decode(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	loaded, err := New(EngineVM).LoadBytecode(bytecode)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	result, err := New(EngineVM).Run(loaded)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.Inspect() != "42" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+
+	if _, err := New(EngineEval).LoadBytecode(bytecode); err == nil {
+		t.Errorf("expected error loading bytecode into the evaluator")
+	}
+
+	bytecode.Instructions = append(bytecode.Instructions, byte(code.OpConstant))
+	_, err = New(EngineVM).LoadBytecode(bytecode)
+	if _, ok := err.(*vm.VerifyError); !ok {
+		t.Errorf("expected *vm.VerifyError loading truncated bytecode, got %T (%v)", err, err)
+	}
+}
+
+func TestMacros(t *testing.T) {
+	for _, engine := range engines {
+		interp := New(engine)
+
+		_, err := interp.Eval(`let reverse = macro(a, b) { quote(unquote(b) - unquote(a)) };`)
+		if err != nil {
+			t.Fatalf("engine %s: unexpected error: %s", engine, err)
+		}
+
+		result, err := interp.Eval("reverse(2 + 2, 10 - 5)")
+		if err != nil {
+			t.Fatalf("engine %s: unexpected error: %s", engine, err)
+		}
+		if result.Inspect() != "1" {
+			t.Errorf("engine %s: wrong result. got=%s", engine, result.Inspect())
+		}
+
+		result, err = interp.Eval("quote(foobar + 1)")
+		if err != nil {
+			t.Fatalf("engine %s: unexpected error: %s", engine, err)
+		}
+		if result.Inspect() != "quote((foobar + 1))" {
+			t.Errorf("engine %s: wrong result. got=%s", engine, result.Inspect())
+		}
+
+		_, err = interp.Eval("let m = macro() { 1 }; m()")
+		if _, ok := err.(*CompileError); !ok {
+			t.Errorf("engine %s: expected CompileError. got=%T (%v)", engine, err, err)
+		}
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	type user struct {
+		Name  string
+		Admin bool `monkey:"admin"`
+	}
+
+	for _, engine := range engines {
+		interp := New(engine)
+
+		err := interp.RegisterFunc("split", "", func(s, sep string) ([]string, error) {
+			if sep == "" {
+				return nil, fmt.Errorf("empty separator")
+			}
+			return strings.Split(s, sep), nil
+		})
+		if err != nil {
+			t.Fatalf("engine %s: unexpected error: %s", engine, err)
+		}
+		err = interp.RegisterFunc("user", "", func(name string) user {
+			return user{Name: name, Admin: name == "root"}
+		})
+		if err != nil {
+			t.Fatalf("engine %s: unexpected error: %s", engine, err)
+		}
+
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{`split("a,b,c", ",")[2]`, "c"},
+			{`len(split("a b", " "))`, "2"},
+			{`user("root")["admin"]`, "true"},
+			{`if (user("bob")["admin"]) { 1 } else { 2 }`, "2"},
+			{`user("bob")["Name"]`, "bob"},
+		}
+
+		for _, tt := range tests {
+			result, err := interp.Eval(tt.input)
+			if err != nil {
+				t.Fatalf("engine %s: input %q: unexpected error: %s",
+					engine, tt.input, err)
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("engine %s: input %q: wrong result. want=%q, got=%q",
+					engine, tt.input, tt.expected, result.Inspect())
+			}
+		}
+
+		errorTests := []struct {
+			input    string
+			expected string
+		}{
+			{`split("a", "")`, "empty separator"},
+			{`split(1, ",")`, "split: argument 1: cannot use INTEGER as string"},
+			{`split("a")`, "wrong number of arguments. got=1, want=2"},
+		}
+
+		for _, tt := range errorTests {
+			_, err := interp.Eval(tt.input)
+			runtimeErr, ok := err.(*RuntimeError)
+			if !ok || runtimeErr.Message != tt.expected {
+				t.Errorf("engine %s: input %q: wrong error. want=%q, got=%v",
+					engine, tt.input, tt.expected, err)
+			}
+		}
+	}
+
+	if err := New(EngineVM).RegisterFunc("notAFunc", "", 42); err == nil {
+		t.Errorf("expected error registering a non-function")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	for _, engine := range engines {
+		interp := New(engine)
+		other := New(engine)
+
+		interp.Register("triple", 1, "triple(x) multiplies x by three",
+			func(args ...object.Object) object.Object {
+				n, ok := args[0].(*object.Integer)
+				if !ok {
+					return &object.Error{Message: "triple needs an INTEGER"}
+				}
+				return &object.Integer{Value: n.Value * 3}
+			})
+
+		result, err := interp.Eval("let f = fn(x) { triple(x) + len([1]) }; f(4)")
+		if err != nil {
+			t.Fatalf("engine %s: unexpected error: %s", engine, err)
+		}
+		if result.Inspect() != "13" {
+			t.Errorf("engine %s: wrong result. got=%s", engine, result.Inspect())
+		}
+
+		_, err = interp.Eval("triple(1, 2)")
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok || runtimeErr.Message != "wrong number of arguments. got=2, want=1" {
+			t.Errorf("engine %s: wrong arity error. got=%v", engine, err)
+		}
+
+		if _, err := other.Eval("triple(1)"); err == nil {
+			t.Errorf("engine %s: builtin leaked into another interpreter", engine)
+		}
+
+		if _, _, ok := object.Builtins.Lookup("triple"); ok {
+			t.Errorf("engine %s: builtin leaked into the default registry", engine)
+		}
+	}
+}
+
+func TestGlobalsShadowBuiltinsAcrossEngines(t *testing.T) {
+	double := func(args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	}
+
+	for _, engine := range engines {
+		interp := New(engine)
+		interp.Set("before", &object.Integer{Value: 1})
+		interp.Register("before", 1, "", double)
+		interp.Register("after", 1, "", double)
+		interp.Set("after", &object.Integer{Value: 2})
+		if _, err := interp.Eval("let defined = 3;"); err != nil {
+			t.Fatalf("engine %s: unexpected error: %s", engine, err)
+		}
+		interp.Register("defined", 1, "", double)
+		interp.Register("builtin", 1, "", double)
+
+		result, err := interp.Eval("[before, after, defined, builtin(4)]")
+		if err != nil {
+			t.Fatalf("engine %s: unexpected error: %s", engine, err)
+		}
+		if result.Inspect() != "[1, 2, 3, 8]" {
+			t.Errorf("engine %s: wrong result. got=%s", engine, result.Inspect())
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2 * 3", "7"},
+		{`"mon" + "key"`, "monkey"},
+		{"let add = fn(a, b) { a + b }; add(2, 3)", "5"},
+		{"[1, 2, 3][1]", "2"},
+		{"", "null"},
+		{"let x = 5;", "null"},
+		{"let x = 5; x;", "5"},
+	}
+
+	for _, engine := range engines {
+		for _, tt := range tests {
+			interp := New(engine)
+			result, err := interp.Eval(tt.input)
+			if err != nil {
+				t.Fatalf("engine %s: input %q: unexpected error: %s",
+					engine, tt.input, err)
+			}
+
+			if result.Inspect() != tt.expected {
+				t.Errorf("engine %s: input %q: wrong result. want=%q, got=%q",
+					engine, tt.input, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestStatePersistsBetweenCalls(t *testing.T) {
+	for _, engine := range engines {
+		interp := New(engine)
+
+		_, err := interp.Eval("let double = fn(x) { x * 2 };")
+		if err != nil {
+			t.Fatalf("engine %s: unexpected error: %s", engine, err)
+		}
+
+		result, err := interp.Eval("double(21)")
+		if err != nil {
+			t.Fatalf("engine %s: unexpected error: %s", engine, err)
+		}
+
+		if result.Inspect() != "42" {
+			t.Errorf("engine %s: wrong result. want=42, got=%s",
+				engine, result.Inspect())
+		}
+	}
+}
+
+func TestSetAndGetGlobals(t *testing.T) {
+	for _, engine := range engines {
+		interp := New(engine)
+		interp.Set("name", &object.String{Value: "Monkey"})
+
+		result, err := interp.Eval(`let greeting = "Hello " + name; greeting`)
+		if err != nil {
+			t.Fatalf("engine %s: unexpected error: %s", engine, err)
+		}
+		if result.Inspect() != "Hello Monkey" {
+			t.Errorf("engine %s: wrong result. got=%s", engine, result.Inspect())
+		}
+
+		greeting, ok := interp.Get("greeting")
+		if !ok {
+			t.Fatalf("engine %s: global greeting not found", engine)
+		}
+		if greeting.Inspect() != "Hello Monkey" {
+			t.Errorf("engine %s: wrong global. got=%s", engine, greeting.Inspect())
+		}
+
+		if _, ok := interp.Get("undefined"); ok {
+			t.Errorf("engine %s: found global that was never set", engine)
+		}
+	}
+}
+
+func TestStdout(t *testing.T) {
+	for _, engine := range engines {
+		var first, second bytes.Buffer
+
+		interp1 := New(engine)
+		interp1.Stdout = &first
+		interp2 := New(engine)
+		interp2.Stdout = &second
+
+		if _, err := interp1.Eval(`puts("one")`); err != nil {
+			t.Fatalf("engine %s: unexpected error: %s", engine, err)
+		}
+		if _, err := interp2.Eval(`puts("two", 2)`); err != nil {
+			t.Fatalf("engine %s: unexpected error: %s", engine, err)
+		}
+
+		if first.String() != "one\n" {
+			t.Errorf("engine %s: wrong output. got=%q", engine, first.String())
+		}
+		if second.String() != "two\n2\n" {
+			t.Errorf("engine %s: wrong output. got=%q", engine, second.String())
+		}
+	}
+}
+
+func TestCompileAndRun(t *testing.T) {
+	for _, engine := range engines {
+		interp := New(engine)
+		interp.Set("x", &object.Integer{Value: 1})
+
+		program, err := interp.Compile("x + 1")
+		if err != nil {
+			t.Fatalf("engine %s: unexpected error: %s", engine, err)
+		}
+
+		for _, expected := range []string{"2", "11"} {
+			result, err := interp.Run(program)
+			if err != nil {
+				t.Fatalf("engine %s: unexpected error: %s", engine, err)
+			}
+			if result.Inspect() != expected {
+				t.Errorf("engine %s: wrong result. want=%s, got=%s",
+					engine, expected, result.Inspect())
+			}
+			interp.Set("x", &object.Integer{Value: 10})
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, engine := range engines {
+		interp := New(engine)
+		interp.Stderr = &bytes.Buffer{}
+
+		_, err := interp.Eval("let = 5;")
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("engine %s: expected SyntaxError. got=%T (%v)", engine, err, err)
+		}
+
+		_, err = interp.Eval("1 + true")
+		if _, ok := err.(*RuntimeError); !ok {
+			t.Errorf("engine %s: expected RuntimeError. got=%T (%v)", engine, err, err)
+		}
+
+		interp.Register("crash", 0, "", func(args ...object.Object) object.Object {
+			panic("boom")
+		})
+		_, err = interp.Eval("let f = fn() { crash() }; f()")
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok || runtimeErr.Message != "internal error: boom" {
+			t.Errorf("engine %s: panic not returned as error. got=%v", engine, err)
+		}
+		if result, err := interp.Eval("1 + 1"); err != nil || result.Inspect() != "2" {
+			t.Errorf("engine %s: interpreter unusable after a panic. got=%v, %v",
+				engine, result, err)
+		}
+	}
+
+	interp := New(EngineVM)
+	_, err := interp.Eval("undefinedVariable")
+	if _, ok := err.(*CompileError); !ok {
+		t.Errorf("expected CompileError. got=%T (%v)", err, err)
+	}
+}
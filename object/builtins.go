@@ -2,51 +2,131 @@ package object
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
 )
 
-// Builtins are the supported built-in functions for Monkey
-var Builtins = []struct {
-	Name    string
-	Builtin *Builtin
-}{
-	{
-		"len",
-		&Builtin{Fn: func(args ...Object) Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
+// Variadic is the arity of builtins that accept any number of arguments
+const Variadic = -1
+
+// BuiltinRegistry holds the built-in functions available to an interpreter.
+// Compiled code refers to builtins by their index in the registry, so the
+// compiler and the VM running its output must share a registry.
+type BuiltinRegistry struct {
+	builtins []*Builtin
+	index    map[string]int
+}
+
+// NewBuiltinRegistry creates an empty registry
+func NewBuiltinRegistry() *BuiltinRegistry {
+	return &BuiltinRegistry{
+		builtins: []*Builtin{},
+		index:    make(map[string]int),
+	}
+}
+
+// Register makes fn callable from Monkey code as name. Unless arity is
+// Variadic, calls with a different number of arguments fail before fn runs.
+// Registering a name again replaces the previous function but keeps its
+// index, so code compiled against the registry stays valid.
+func (r *BuiltinRegistry) Register(
+	name string,
+	arity int,
+	doc string,
+	fn BuiltinFunction,
+) *Builtin {
+	builtin := &Builtin{Name: name, Arity: arity, Doc: doc, Fn: checkArity(arity, fn)}
+
+	if i, ok := r.index[name]; ok {
+		r.builtins[i] = builtin
+		return builtin
+	}
+
+	r.index[name] = len(r.builtins)
+	r.builtins = append(r.builtins, builtin)
+	return builtin
+}
+
+// Lookup returns the builtin registered as name and its index
+func (r *BuiltinRegistry) Lookup(name string) (*Builtin, int, bool) {
+	i, ok := r.index[name]
+	if !ok {
+		return nil, -1, false
+	}
+	return r.builtins[i], i, true
+}
+
+// Get returns the builtin at index i, nil if there is none
+func (r *BuiltinRegistry) Get(i int) *Builtin {
+	if i < 0 || i >= len(r.builtins) {
+		return nil
+	}
+	return r.builtins[i]
+}
+
+// Len returns the number of registered builtins
+func (r *BuiltinRegistry) Len() int {
+	return len(r.builtins)
+}
+
+// All returns the registered builtins, ordered by index
+func (r *BuiltinRegistry) All() []*Builtin {
+	all := make([]*Builtin, len(r.builtins))
+	copy(all, r.builtins)
+	return all
+}
+
+// checkArity wraps fn so it returns an error when called with the wrong
+// number of arguments
+func checkArity(arity int, fn BuiltinFunction) BuiltinFunction {
+	if arity == Variadic {
+		return fn
+	}
+
+	return func(args ...Object) Object {
+		if len(args) != arity {
+			return newError("wrong number of arguments. got=%d, want=%d",
+				len(args), arity)
+		}
+		return fn(args...)
+	}
+}
+
+// Builtins are the supported built-in functions for Monkey, puts writes to
+// the process' standard output. Interpreters that need their own builtins
+// should create a registry with NewDefaultBuiltins instead of modifying it.
+var Builtins = NewDefaultBuiltins(os.Stdout)
 
+// NewDefaultBuiltins creates a registry with the supported built-in functions
+// for Monkey, puts writes to stdout. The builtins are always registered in
+// the same order.
+func NewDefaultBuiltins(stdout io.Writer) *BuiltinRegistry {
+	r := NewBuiltinRegistry()
+
+	r.Register("len", 1, "len(x) returns the number of characters in a string or elements in an array",
+		func(args ...Object) Object {
 			switch arg := args[0].(type) {
 			case *Array:
 				return &Integer{Value: int64(len(arg.Elements))}
 			case *String:
-				return &Integer{Value: int64(len(arg.Value))}
+				return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 			default:
 				return newError("argument to `len` not supported, got %s",
 					args[0].Type())
 			}
-		},
-		},
-	},
-	{
-		"puts",
-		&Builtin{Fn: func(args ...Object) Object {
+		})
+
+	r.Register("puts", Variadic, "puts(args...) prints each argument on its own line",
+		func(args ...Object) Object {
 			for _, arg := range args {
-				fmt.Println(arg.Inspect())
+				fmt.Fprintln(stdout, arg.Inspect())
 			}
 			return nil
-		},
-		},
-	},
-	{
-		"first",
-		&Builtin{Fn: func(args ...Object) Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
+		})
 
+	r.Register("first", 1, "first(array) returns the first element of an array",
+		func(args ...Object) Object {
 			if args[0].Type() != ARRAY_OBJ {
 				return newError("argument to `first` must be ARRAY, got %s",
 					args[0].Type())
@@ -58,17 +138,10 @@ var Builtins = []struct {
 			}
 
 			return nil
-		},
-		},
-	},
-	{
-		"last",
-		&Builtin{Fn: func(args ...Object) Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
+		})
 
+	r.Register("last", 1, "last(array) returns the last element of an array",
+		func(args ...Object) Object {
 			if args[0].Type() != ARRAY_OBJ {
 				return newError("argument to `last` must be ARRAY, got %s",
 					args[0].Type())
@@ -81,16 +154,10 @@ var Builtins = []struct {
 			}
 
 			return nil
-		},
-		},
-	},
-	{
-		"rest",
-		&Builtin{Fn: func(args ...Object) Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
+		})
+
+	r.Register("rest", 1, "rest(array) returns a new array without the first element",
+		func(args ...Object) Object {
 			if args[0].Type() != ARRAY_OBJ {
 				return newError("argument to `rest` must be ARRAY, got %s",
 					args[0].Type())
@@ -105,16 +172,10 @@ var Builtins = []struct {
 			}
 
 			return nil
-		},
-		},
-	},
-	{
-		"push",
-		&Builtin{Fn: func(args ...Object) Object {
-			if len(args) != 2 {
-				return newError("wrong number of arguments. got=%d, want=2",
-					len(args))
-			}
+		})
+
+	r.Register("push", 2, "push(array, x) returns a new array with x appended",
+		func(args ...Object) Object {
 			if args[0].Type() != ARRAY_OBJ {
 				return newError("argument to `push` must be ARRAY, got %s",
 					args[0].Type())
@@ -128,18 +189,9 @@ var Builtins = []struct {
 			newElements[length] = args[1]
 
 			return &Array{Elements: newElements}
-		},
-		},
-	},
-}
+		})
 
-func GetBuiltinByName(name string) *Builtin {
-	for _, def := range Builtins {
-		if def.Name == name {
-			return def.Builtin
-		}
-	}
-	return nil
+	return r
 }
 
 func newError(format string, a ...interface{}) *Error {
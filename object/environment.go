@@ -3,14 +3,35 @@ package object
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+
+	builtins *BuiltinRegistry // only set on the outermost environment
+	depth    int              // number of function calls it is nested in
+	function *Function        // the function called, if any
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.depth = outer.depth
+	return env
+}
+
+// NewCallEnvironment creates the environment of a call to fn, made from
+// the environment caller. Inside of the call the name fn is bound to by let
+// refers to fn itself, even if the name is bound to something else later on,
+// just like in compiled code.
+func NewCallEnvironment(fn *Function, caller *Environment) *Environment {
+	env := NewEnclosedEnvironment(fn.Env)
+	env.depth = caller.depth + 1
+	env.function = fn
 	return env
 }
 
+// Depth reports how many function calls deep the environment is nested
+func (e *Environment) Depth() int {
+	return e.depth
+}
+
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
 	return &Environment{store: s, outer: nil}
@@ -18,6 +39,9 @@ func NewEnvironment() *Environment {
 
 func (e *Environment) Get(name string) (Object, bool) {
 	obj, ok := e.store[name]
+	if !ok && e.isFunctionName(name) {
+		return e.function, true
+	}
 	if !ok && e.outer != nil {
 		obj, ok = e.outer.Get(name)
 	}
@@ -28,3 +52,57 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Assign changes the value name is bound to in the innermost environment
+// that binds it, it reports whether there is such a binding
+func (e *Environment) Assign(name string, val Object) bool {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			return true
+		}
+	}
+	return false
+}
+
+// IsFunctionName reports whether name refers to a function from inside of
+// its own body, rather than to a variable defined in the body
+func (e *Environment) IsFunctionName(name string) bool {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			return false
+		}
+		if env.isFunctionName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Environment) isFunctionName(name string) bool {
+	return e.function != nil && e.function.Name != "" && e.function.Name == name
+}
+
+// NewEnvironmentWithBuiltins creates an environment that resolves built-in
+// functions from the given registry instead of the default Builtins
+func NewEnvironmentWithBuiltins(builtins *BuiltinRegistry) *Environment {
+	env := NewEnvironment()
+	env.builtins = builtins
+	return env
+}
+
+// GetBuiltin looks up the built-in function called name that is visible
+// from this environment
+func (e *Environment) GetBuiltin(name string) (*Builtin, bool) {
+	if e.outer != nil {
+		return e.outer.GetBuiltin(name)
+	}
+
+	builtins := e.builtins
+	if builtins == nil {
+		builtins = Builtins
+	}
+
+	builtin, _, ok := builtins.Lookup(name)
+	return builtin, ok
+}
@@ -0,0 +1,96 @@
+package object
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Iterator steps through the elements of an array, the keys of a hash or
+// the characters of a string, which is what for loops iterate over. Hash
+// keys are returned in sorted order, so iterating a hash is deterministic.
+type Iterator struct {
+	items  []Object
+	chars  []rune // of a string, converted to String objects on demand
+	offset int
+}
+
+// NewIterator returns an iterator over obj, or an error if obj can't be
+// iterated over
+func NewIterator(obj Object) (*Iterator, error) {
+	switch obj := obj.(type) {
+	case *Array:
+		return &Iterator{items: obj.Elements}, nil
+	case *Hash:
+		return &Iterator{items: sortedKeys(obj)}, nil
+	case *String:
+		return &Iterator{chars: []rune(obj.Value)}, nil
+	default:
+		return nil, fmt.Errorf("cannot iterate over %s", obj.Type())
+	}
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return "iterator" }
+
+// Next returns the next item, ok is false once all items have been returned
+func (it *Iterator) Next() (item Object, ok bool) {
+	if it.chars != nil {
+		if it.offset >= len(it.chars) {
+			return nil, false
+		}
+		it.offset++
+		return &String{Value: string(it.chars[it.offset-1])}, true
+	}
+
+	if it.offset >= len(it.items) {
+		return nil, false
+	}
+	it.offset++
+	return it.items[it.offset-1], true
+}
+
+// sortedKeys returns the keys of a hash ordered by type name and then by
+// value, numbers are compared numerically
+func sortedKeys(h *Hash) []Object {
+	keys := make([]Object, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		keys = append(keys, pair.Key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a, ok := a.(*Integer); ok {
+			if b, ok := b.(*Integer); ok {
+				return a.Value < b.Value
+			}
+		}
+		if isNumber(a) && isNumber(b) {
+			return toFloat(a) < toFloat(b)
+		}
+		if a.Type() != b.Type() {
+			return a.Type() < b.Type()
+		}
+
+		switch a := a.(type) {
+		case *String:
+			return a.Value < b.(*String).Value
+		case *Boolean:
+			return !a.Value && b.(*Boolean).Value
+		default:
+			return false
+		}
+	})
+
+	return keys
+}
+
+func isNumber(obj Object) bool {
+	return obj.Type() == INTEGER_OBJ || obj.Type() == FLOAT_OBJ
+}
+
+func toFloat(obj Object) float64 {
+	if i, ok := obj.(*Integer); ok {
+		return float64(i.Value)
+	}
+	return obj.(*Float).Value
+}
@@ -0,0 +1,555 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Values are converted between Go and Monkey as follows:
+//
+//	Go                               Monkey
+//	bool                             BOOLEAN
+//	int*, uint*                      INTEGER
+//	float32, float64                 FLOAT, INTEGER is accepted as well
+//	string                           STRING
+//	slice, array                     ARRAY
+//	map                              HASH
+//	struct                           HASH keyed by field name
+//	error                            ERROR
+//	func                             BUILTIN
+//	nil, nil pointer                 NULL
+//
+// Struct fields are keyed by their name unless they carry a `monkey:"name"`
+// tag, a tag of "-" and unexported fields are skipped. Go values of type
+// Object are passed through unchanged.
+
+var (
+	objectType = reflect.TypeOf((*Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// FromGo converts the Go value v to a Monkey object
+func FromGo(v interface{}) (Object, error) {
+	if v == nil {
+		return NULL, nil
+	}
+	return fromValue(reflect.ValueOf(v), map[visit]bool{})
+}
+
+// ToGo converts obj to a Go value and stores it in the value pointed to by
+// ptr. Into an empty interface, integers become int64, arrays
+// []interface{} and hashes map[interface{}]interface{}.
+func ToGo(obj Object, ptr interface{}) error {
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("ToGo needs a non-nil pointer, got %T", ptr)
+	}
+
+	v, err := toValue(obj, rv.Type().Elem(), map[Object]bool{})
+	if err != nil {
+		return err
+	}
+	rv.Elem().Set(v)
+	return nil
+}
+
+// WrapFunc turns the Go function fn into a builtin. Arguments are converted
+// with ToGo, and the first result with FromGo. If the last result of fn is
+// an error, a non-nil error is returned to Monkey code as an ERROR.
+//
+//	WrapFunc("split", func(s, sep string) ([]string, error) { ... })
+func WrapFunc(name string, fn interface{}) (*Builtin, error) {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func {
+		return nil, fmt.Errorf("builtin %s must be a function, got %T", name, fn)
+	}
+
+	returnsErr := ft.NumOut() > 0 && ft.Out(ft.NumOut()-1) == errorType
+	results := ft.NumOut()
+	if returnsErr {
+		results--
+	}
+	if results > 1 {
+		return nil, fmt.Errorf("builtin %s must return at most one value and an error",
+			name)
+	}
+
+	arity := ft.NumIn()
+	if ft.IsVariadic() {
+		arity = Variadic
+	}
+
+	call := func(args ...Object) Object {
+		in, err := funcArgs(ft, args)
+		if err != nil {
+			return newError("%s: %s", name, err)
+		}
+
+		out := fv.Call(in)
+		if returnsErr {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return &Error{Message: err.Error()}
+			}
+		}
+		if results == 0 {
+			return nil
+		}
+
+		result, err := fromValue(out[0], map[visit]bool{})
+		if err != nil {
+			return newError("%s: %s", name, err)
+		}
+		return result
+	}
+
+	return &Builtin{Name: name, Arity: arity, Fn: checkArity(arity, call)}, nil
+}
+
+// RegisterFunc registers the Go function fn as name, see WrapFunc
+func (r *BuiltinRegistry) RegisterFunc(
+	name string,
+	doc string,
+	fn interface{},
+) (*Builtin, error) {
+	wrapped, err := WrapFunc(name, fn)
+	if err != nil {
+		return nil, err
+	}
+	return r.Register(name, wrapped.Arity, doc, wrapped.Fn), nil
+}
+
+// funcArgs converts the arguments of a call to the parameter types of ft
+func funcArgs(ft reflect.Type, args []Object) ([]reflect.Value, error) {
+	fixed := ft.NumIn()
+	if ft.IsVariadic() {
+		fixed--
+		if len(args) < fixed {
+			return nil, fmt.Errorf("wrong number of arguments. got=%d, want at least %d",
+				len(args), fixed)
+		}
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var typ reflect.Type
+		if i < fixed {
+			typ = ft.In(i)
+		} else {
+			typ = ft.In(fixed).Elem()
+		}
+
+		v, err := toValue(arg, typ, map[Object]bool{})
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %s", i+1, err)
+		}
+		in[i] = v
+	}
+
+	return in, nil
+}
+
+// visit is a Go slice, map or pointer being converted
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// fromValue converts v to a Monkey object. Go values can contain
+// themselves through slices, maps and pointers, seen holds the ones being
+// converted so those are reported instead of recursing forever.
+func fromValue(v reflect.Value, seen map[visit]bool) (Object, error) {
+	if !v.IsValid() {
+		return NULL, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Ptr:
+		if !v.IsNil() {
+			key := visit{v.Pointer(), v.Type()}
+			if seen[key] {
+				return nil, fmt.Errorf("cannot convert Go value of type %s that contains itself", v.Type())
+			}
+			seen[key] = true
+			defer delete(seen, key)
+		}
+	}
+
+	if v.Type().Implements(objectType) {
+		if isNil(v) {
+			return NULL, nil
+		}
+		return v.Interface().(Object), nil
+	}
+	if v.Type().Implements(errorType) {
+		if isNil(v) {
+			return NULL, nil
+		}
+		return &Error{Message: v.Interface().(error).Error()}, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return TRUE, nil
+		}
+		return FALSE, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows INTEGER", v.Uint())
+		}
+		return &Integer{Value: int64(v.Uint())}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return NULL, nil
+		}
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			el, err := fromValue(v.Index(i), seen)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = el
+		}
+		return &Array{Elements: elements}, nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		pairs := make(map[HashKey]HashPair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := fromValue(iter.Key(), seen)
+			if err != nil {
+				return nil, err
+			}
+			hashKey, ok := key.(Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			value, err := fromValue(iter.Value(), seen)
+			if err != nil {
+				return nil, err
+			}
+			pairs[hashKey.HashKey()] = HashPair{Key: key, Value: value}
+		}
+		return &Hash{Pairs: pairs}, nil
+
+	case reflect.Struct:
+		pairs := make(map[HashKey]HashPair)
+		for _, field := range structFields(v.Type()) {
+			value, err := fromValue(v.FieldByIndex(field.index), seen)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %s", field.name, err)
+			}
+			key := &String{Value: field.name}
+			pairs[key.HashKey()] = HashPair{Key: key, Value: value}
+		}
+		return &Hash{Pairs: pairs}, nil
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return fromValue(v.Elem(), seen)
+
+	case reflect.Func:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return WrapFunc("func", v.Interface())
+	}
+
+	return nil, fmt.Errorf("cannot convert Go value of type %s", v.Type())
+}
+
+// toValue converts obj to a value of type typ. Arrays and hashes can contain
+// themselves, seen holds the ones being converted.
+func toValue(obj Object, typ reflect.Type, seen map[Object]bool) (reflect.Value, error) {
+	if obj == nil {
+		obj = NULL
+	}
+
+	// objects are passed through to parameters like Object or *Hash, while
+	// an empty interface gets the natural Go representation
+	emptyInterface := typ.Kind() == reflect.Interface && typ.NumMethod() == 0
+	if reflect.TypeOf(obj).AssignableTo(typ) && !emptyInterface {
+		return reflect.ValueOf(obj), nil
+	}
+
+	if _, ok := obj.(*Null); ok {
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func:
+			return reflect.Zero(typ), nil
+		}
+	}
+
+	if typ == errorType {
+		if errObj, ok := obj.(*Error); ok {
+			return reflect.ValueOf(errors.New(errObj.Message)), nil
+		}
+	}
+
+	switch typ.Kind() {
+	case reflect.Interface:
+		if emptyInterface {
+			v, err := toInterface(obj, seen)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			if v == nil {
+				return reflect.Zero(typ), nil
+			}
+			return reflect.ValueOf(v), nil
+		}
+
+	case reflect.Bool:
+		if b, ok := obj.(*Boolean); ok {
+			return reflect.ValueOf(b.Value).Convert(typ), nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*Integer); ok {
+			v := reflect.New(typ).Elem()
+			if v.OverflowInt(i.Value) {
+				return reflect.Value{}, fmt.Errorf("%d overflows %s", i.Value, typ)
+			}
+			v.SetInt(i.Value)
+			return v, nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*Integer); ok {
+			v := reflect.New(typ).Elem()
+			if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+				return reflect.Value{}, fmt.Errorf("%d overflows %s", i.Value, typ)
+			}
+			v.SetUint(uint64(i.Value))
+			return v, nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		switch n := obj.(type) {
+		case *Float:
+			return reflect.ValueOf(n.Value).Convert(typ), nil
+		case *Integer:
+			return reflect.ValueOf(float64(n.Value)).Convert(typ), nil
+		}
+
+	case reflect.String:
+		if s, ok := obj.(*String); ok {
+			return reflect.ValueOf(s.Value).Convert(typ), nil
+		}
+
+	case reflect.Slice:
+		if arr, ok := obj.(*Array); ok {
+			if err := enter(obj, seen); err != nil {
+				return reflect.Value{}, err
+			}
+			defer delete(seen, obj)
+			v := reflect.MakeSlice(typ, len(arr.Elements), len(arr.Elements))
+			if err := setElements(v, arr.Elements, seen); err != nil {
+				return reflect.Value{}, err
+			}
+			return v, nil
+		}
+
+	case reflect.Array:
+		if arr, ok := obj.(*Array); ok {
+			if err := enter(obj, seen); err != nil {
+				return reflect.Value{}, err
+			}
+			defer delete(seen, obj)
+			if len(arr.Elements) != typ.Len() {
+				return reflect.Value{}, fmt.Errorf("need %d elements for %s, got %d",
+					typ.Len(), typ, len(arr.Elements))
+			}
+			v := reflect.New(typ).Elem()
+			if err := setElements(v, arr.Elements, seen); err != nil {
+				return reflect.Value{}, err
+			}
+			return v, nil
+		}
+
+	case reflect.Map:
+		if hash, ok := obj.(*Hash); ok {
+			if err := enter(obj, seen); err != nil {
+				return reflect.Value{}, err
+			}
+			defer delete(seen, obj)
+			v := reflect.MakeMapWithSize(typ, len(hash.Pairs))
+			for _, pair := range hash.Pairs {
+				key, err := toValue(pair.Key, typ.Key(), seen)
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("key %s: %s", pair.Key.Inspect(), err)
+				}
+				value, err := toValue(pair.Value, typ.Elem(), seen)
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("key %s: %s", pair.Key.Inspect(), err)
+				}
+				v.SetMapIndex(key, value)
+			}
+			return v, nil
+		}
+
+	case reflect.Struct:
+		if hash, ok := obj.(*Hash); ok {
+			if err := enter(obj, seen); err != nil {
+				return reflect.Value{}, err
+			}
+			defer delete(seen, obj)
+			v := reflect.New(typ).Elem()
+			for _, field := range structFields(typ) {
+				key := &String{Value: field.name}
+				pair, ok := hash.Pairs[key.HashKey()]
+				if !ok {
+					continue
+				}
+				value, err := toValue(pair.Value, field.typ, seen)
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("field %s: %s", field.name, err)
+				}
+				v.FieldByIndex(field.index).Set(value)
+			}
+			return v, nil
+		}
+
+	case reflect.Ptr:
+		elem, err := toValue(obj, typ.Elem(), seen)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.New(typ.Elem())
+		v.Elem().Set(elem)
+		return v, nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", obj.Type(), typ)
+}
+
+// toInterface converts obj to its natural Go representation
+func toInterface(obj Object, seen map[Object]bool) (interface{}, error) {
+	if err := enter(obj, seen); err != nil {
+		return nil, err
+	}
+	defer delete(seen, obj)
+
+	switch obj := obj.(type) {
+	case *Null:
+		return nil, nil
+	case *Boolean:
+		return obj.Value, nil
+	case *Integer:
+		return obj.Value, nil
+	case *Float:
+		return obj.Value, nil
+	case *String:
+		return obj.Value, nil
+	case *Error:
+		return errors.New(obj.Message), nil
+	case *Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, el := range obj.Elements {
+			v, err := toInterface(el, seen)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = v
+		}
+		return elements, nil
+	case *Hash:
+		m := make(map[interface{}]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key, err := toInterface(pair.Key, seen)
+			if err != nil {
+				return nil, err
+			}
+			value, err := toInterface(pair.Value, seen)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	default:
+		return obj, nil
+	}
+}
+
+func setElements(v reflect.Value, elements []Object, seen map[Object]bool) error {
+	for i, el := range elements {
+		ev, err := toValue(el, v.Type().Elem(), seen)
+		if err != nil {
+			return fmt.Errorf("element %d: %s", i, err)
+		}
+		v.Index(i).Set(ev)
+	}
+	return nil
+}
+
+type structField struct {
+	name  string
+	index []int
+	typ   reflect.Type
+}
+
+// structFields lists the fields of a struct type that are converted
+func structFields(typ reflect.Type) []structField {
+	fields := []structField{}
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("monkey"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+
+		fields = append(fields, structField{name: name, index: f.Index, typ: f.Type})
+	}
+	return fields
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func:
+		return v.IsNil()
+	}
+	return false
+}
+
+// enter marks the array or hash obj as being converted, it fails if obj
+// already is, because it contains itself
+func enter(obj Object, seen map[Object]bool) error {
+	switch obj.(type) {
+	case *Array, *Hash:
+		if seen[obj] {
+			return fmt.Errorf("cannot convert %s that contains itself", obj.Type())
+		}
+		seen[obj] = true
+	}
+	return nil
+}
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"monkey/ast"
 	"monkey/code"
+	"monkey/token"
+	"strconv"
 	"strings"
 )
 
@@ -13,6 +16,7 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ           = "INTEGER"
+	FLOAT_OBJ             = "FLOAT"
 	BOOLEAN_OBJ           = "BOOLEAN"
 	NULL_OBJ              = "NULL"
 	RETURN_VALUE_OBJ      = "RETURN VALUE"
@@ -26,6 +30,9 @@ const (
 	MACRO_OBJ             = "MACRO"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
 	CLOSURE_OBJ           = "CLOSURE"
+	ITERATOR_OBJ          = "ITERATOR"
+	BRANCH_OBJ            = "BRANCH"
+	CELL_OBJ              = "CELL"
 )
 
 // Object is an interface for monkey's internal object system
@@ -49,11 +56,41 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 
+// Float is a 64-bit floating point number. Arithmetic mixing integers and
+// floats converts the integer to a float.
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect formats the float with as few digits as needed to read it back
+// as a float literal, without an exponent since the lexer doesn't support
+// them. Whole numbers keep a ".0" so they don't look like integers.
+// Infinities and NaN have no literal, they are printed as "+Inf", "-Inf"
+// and "NaN".
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'f', -1, 64)
+	if !strings.ContainsAny(s, ".IN") {
+		s += ".0"
+	}
+	return s
+}
+
 type Null struct{}
 
 func (n *Null) Type() ObjectType { return NULL_OBJ }
 func (n *Null) Inspect() string  { return "null" }
 
+var (
+	// TRUE, FALSE and NULL are the unique boolean and null values. Both
+	// backends compare against them, so values built outside of Monkey code
+	// must use them instead of allocating new ones.
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
+)
+
 type ReturnValue struct {
 	Value Object
 }
@@ -61,27 +98,103 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Branch is the result of a break or continue statement in the evaluator,
+// it is passed up to the enclosing loop like a ReturnValue to its function
+type Branch struct {
+	Continue bool // continue with the next iteration instead of leaving the loop
+}
+
+func (b *Branch) Type() ObjectType { return BRANCH_OBJ }
+func (b *Branch) Inspect() string {
+	if b.Continue {
+		return "continue"
+	}
+	return "break"
+}
+
 /* TODO : Exercise
 As you can see, object.Error is really, really simple. It only wraps a string
 that serves as error message. In a production-ready interpreter we’d want to
-attach a stack trace to such error objects, add the line and column numbers
-of its origin and provide more than just a message. That’s not so hard to do,
-provided that line and column numbers are attached to the tokens by the
-lexer. Since our lexer doesn’t do that, to keep things simple, we only use
-an error message, which still serves us a great deal by giving us some
-feedback and stopping execution.
+attach a stack trace to such error objects and provide more than just a
+message.
 */
 type Error struct {
 	Message string
+	Pos     token.Position // where in the source the error originated
+	Stack   StackTrace     // the calls the error propagated out of
+	Value   Object         // the thrown value, nil for runtime errors
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
+
+// Exception returns the value a catch block receives for the error: the
+// thrown value, or the message for errors raised by the interpreter itself
+func (e *Error) Exception() Object {
+	if e.Value != nil {
+		return e.Value
+	}
+	return &String{Value: e.Message}
+}
+
+// AddFrame records that the error propagated out of a call of the function
+// name made at callSite. Frames are added while the error unwinds, so the
+// innermost call comes first.
+func (e *Error) AddFrame(name string, callSite token.Position) {
+	pos := e.Pos
+	if n := len(e.Stack); n > 0 {
+		pos = e.Stack[n-1].CallSite
+	}
+	e.Stack = append(e.Stack, StackFrame{Function: name, Pos: pos, CallSite: callSite})
+}
+
+// MainFunction is the name of the frame running the top level of a program
+const MainFunction = "<main>"
+
+// StackFrame is a call of a Monkey function that was active when a runtime
+// error occurred
+type StackFrame struct {
+	Function string         // name the function was bound to, if any
+	Pos      token.Position // where execution was inside of the function
+	CallSite token.Position // where the function was called from
+}
+
+// StackTrace lists the active calls when a runtime error occurred,
+// innermost call first
+type StackTrace []StackFrame
+
+// String formats the trace with one call per line
+//
+//	at inner (script.mk:3:7)
+//	at outer (script.mk:6:3)
+//	at <main> (script.mk:8:1)
+func (s StackTrace) String() string {
+	var out bytes.Buffer
+	for _, frame := range s {
+		name := frame.Function
+		if name == "" {
+			name = "<anonymous>"
+		}
+
+		if frame.Pos.IsValid() {
+			fmt.Fprintf(&out, "  at %s (%s)\n", name, frame.Pos)
+		} else {
+			fmt.Fprintf(&out, "  at %s\n", name)
+		}
+	}
+	return out.String()
+}
 
 type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+	Name       string // name the function was bound to by let, if any
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -112,8 +225,12 @@ func (s *String) Inspect() string  { return s.Value }
 
 type BuiltinFunction func(args ...Object) Object
 
+// Builtin is a function implemented in Go that Monkey code can call
 type Builtin struct {
-	Fn BuiltinFunction
+	Name  string
+	Arity int    // number of arguments the function takes, or Variadic
+	Doc   string // short description of what the function does
+	Fn    BuiltinFunction
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -124,12 +241,38 @@ type Array struct {
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
-func (ao *Array) Inspect() string {
+func (ao *Array) Inspect() string  { return inspect(ao, map[Object]bool{}) }
+
+// inspect formats obj like its Inspect method. Arrays and hashes can contain
+// themselves, the ones already being formatted are printed as [...] and
+// {...} instead of recursing forever.
+func inspect(obj Object, seen map[Object]bool) string {
+	switch obj := obj.(type) {
+	case *Array:
+		if seen[obj] {
+			return "[...]"
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+		return obj.inspect(seen)
+	case *Hash:
+		if seen[obj] {
+			return "{...}"
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+		return obj.inspect(seen)
+	default:
+		return obj.Inspect()
+	}
+}
+
+func (ao *Array) inspect(seen map[Object]bool) string {
 	var out bytes.Buffer
 
 	elements := []string{}
 	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
+		elements = append(elements, inspect(e, seen))
 	}
 
 	out.WriteString("[")
@@ -156,13 +299,15 @@ type Hash struct {
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
+func (h *Hash) Inspect() string  { return inspect(h, map[Object]bool{}) }
+
+func (h *Hash) inspect(seen map[Object]bool) string {
 	var out bytes.Buffer
 
 	pairs := []string{}
 	for _, pair := range h.Pairs {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
-			pair.Key.Inspect(), pair.Value.Inspect()))
+			inspect(pair.Key, seen), inspect(pair.Value, seen)))
 	}
 
 	out.WriteString("{")
@@ -192,6 +337,15 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey of a whole number is the key of the equal integer, so 1.0 and 1
+// refer to the same entry
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+		return (&Integer{Value: int64(f.Value)}).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -239,6 +393,10 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	Name          string         // name the function was bound to by let, if any
+	SourceMap     code.SourceMap // positions the instructions were compiled from
+	FreeNames     []string       // names of the free variables, by index
+	Handlers      code.Handlers  // exception handlers, innermost first
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -250,10 +408,20 @@ func (cf *CompiledFunction) Inspect() string {
 // variables. This is similar to the env field on our regular function type
 type Closure struct {
 	Fn   *CompiledFunction
-	Free []Object
+	Free []*Cell // the captured variables, shared with the enclosing function
 }
 
 func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
 func (c *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", c)
 }
+
+// Cell holds a local variable of a compiled function once a closure
+// captures it. The function and its closures share the cell, so they see
+// each other's assignments.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return c.Value.Inspect() }
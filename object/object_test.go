@@ -1,6 +1,338 @@
 package object
 
-import "testing"
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestEnvironmentAssign(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("a", &Integer{Value: 1})
+	inner := NewEnclosedEnvironment(outer)
+	inner.Set("b", &Integer{Value: 2})
+
+	if !inner.Assign("a", &Integer{Value: 3}) || !inner.Assign("b", &Integer{Value: 4}) {
+		t.Fatalf("Assign failed for bound names")
+	}
+	if inner.Assign("c", &Integer{Value: 5}) {
+		t.Errorf("Assign succeeded for an unbound name")
+	}
+
+	if a, _ := outer.Get("a"); a.Inspect() != "3" {
+		t.Errorf("a not assigned in the outer environment. got=%s", a.Inspect())
+	}
+	if b, _ := inner.Get("b"); b.Inspect() != "4" {
+		t.Errorf("b not assigned. got=%s", b.Inspect())
+	}
+	if _, ok := outer.Get("b"); ok {
+		t.Errorf("b leaked into the outer environment")
+	}
+}
+
+func TestFromGo(t *testing.T) {
+	type point struct {
+		X, Y   int
+		Label  string `monkey:"label"`
+		hidden bool
+		Skip   bool `monkey:"-"`
+	}
+
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{uint8(200), "200"},
+		{int32(-5), "-5"},
+		{1.5, "1.5"},
+		{float32(2), "2.0"},
+		{"monkey", "monkey"},
+		{[]int{1, 2, 3}, "[1, 2, 3]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{[]interface{}{1, "two", nil}, "[1, two, null]"},
+		{map[string]int{"one": 1}, "{one: 1}"},
+		{point{X: 1, Y: 2, Label: "p"}, ""},
+		{&point{}, ""},
+		{(*point)(nil), "null"},
+		{[]int(nil), "null"},
+		{errors.New("boom"), "ERROR: boom"},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.input)
+		if err != nil {
+			t.Fatalf("input %#v: unexpected error: %s", tt.input, err)
+		}
+		if tt.expected != "" && obj.Inspect() != tt.expected {
+			t.Errorf("input %#v: wrong object. want=%q, got=%q",
+				tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	obj, err := FromGo(point{X: 1, Y: 2, Label: "p"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	hash := obj.(*Hash)
+	if len(hash.Pairs) != 3 {
+		t.Fatalf("wrong number of pairs. got=%d", len(hash.Pairs))
+	}
+	label := hash.Pairs[(&String{Value: "label"}).HashKey()]
+	if label.Value.Inspect() != "p" {
+		t.Errorf("wrong label. got=%s", label.Value.Inspect())
+	}
+
+	if obj, _ := FromGo(false); obj != FALSE {
+		t.Errorf("booleans must be converted to the FALSE singleton")
+	}
+
+	if _, err := FromGo(uint64(1 << 63)); err == nil {
+		t.Errorf("expected overflow error")
+	}
+	if _, err := FromGo(1 + 2i); err == nil {
+		t.Errorf("expected error for unsupported type")
+	}
+}
+
+func TestToGo(t *testing.T) {
+	type point struct {
+		X, Y  int
+		Label string `monkey:"label"`
+	}
+
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	for key, value := range map[string]Object{
+		"X":     &Integer{Value: 1},
+		"Y":     &Integer{Value: 2},
+		"label": &String{Value: "p"},
+	} {
+		k := &String{Value: key}
+		hash.Pairs[k.HashKey()] = HashPair{Key: k, Value: value}
+	}
+
+	var p point
+	if err := ToGo(hash, &p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(p, point{X: 1, Y: 2, Label: "p"}) {
+		t.Errorf("wrong struct. got=%+v", p)
+	}
+
+	var m map[string]interface{}
+	if err := ToGo(hash, &m); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if m["X"] != int64(1) || m["label"] != "p" {
+		t.Errorf("wrong map. got=%v", m)
+	}
+
+	arr := &Array{Elements: []Object{&Integer{Value: 1}, TRUE, NULL}}
+	var values []interface{}
+	if err := ToGo(arr, &values); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(values, []interface{}{int64(1), true, nil}) {
+		t.Errorf("wrong slice. got=%#v", values)
+	}
+
+	var ratio float32
+	if err := ToGo(&Integer{Value: 3}, &ratio); err != nil || ratio != 3 {
+		t.Errorf("integers must convert to floats. got=%v (%v)", ratio, err)
+	}
+
+	var small int8
+	if err := ToGo(&Integer{Value: 300}, &small); err == nil {
+		t.Errorf("expected overflow error")
+	}
+	var ints []int
+	if err := ToGo(arr, &ints); err == nil {
+		t.Errorf("expected error converting BOOLEAN to int")
+	}
+
+	var obj Object
+	if err := ToGo(hash, &obj); err != nil || obj != hash {
+		t.Errorf("objects must be passed through unchanged")
+	}
+}
+
+func TestWrapFunc(t *testing.T) {
+	builtin, err := WrapFunc("sum", func(base int, rest ...int) int {
+		for _, n := range rest {
+			base += n
+		}
+		return base
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if builtin.Arity != Variadic {
+		t.Errorf("variadic function must have variadic arity. got=%d", builtin.Arity)
+	}
+
+	result := builtin.Fn(&Integer{Value: 1}, &Integer{Value: 2}, &Integer{Value: 3})
+	if result.Inspect() != "6" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+
+	result = builtin.Fn()
+	if errObj, ok := result.(*Error); !ok ||
+		errObj.Message != "sum: wrong number of arguments. got=0, want at least 1" {
+		t.Errorf("wrong error. got=%s", result.Inspect())
+	}
+
+	noResult, err := WrapFunc("noop", func() {})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result := noResult.Fn(); result != nil {
+		t.Errorf("expected nil result. got=%s", result.Inspect())
+	}
+
+	if _, err := WrapFunc("pair", func() (int, int) { return 1, 2 }); err == nil {
+		t.Errorf("expected error for function with two results")
+	}
+}
+
+func TestBuiltinRegistry(t *testing.T) {
+	r := NewBuiltinRegistry()
+
+	first := r.Register("double", 1, "double(x) doubles an integer",
+		func(args ...Object) Object {
+			return &Integer{Value: args[0].(*Integer).Value * 2}
+		})
+	r.Register("zero", 0, "", func(args ...Object) Object {
+		return &Integer{Value: 0}
+	})
+
+	builtin, index, ok := r.Lookup("double")
+	if !ok || builtin != first || index != 0 {
+		t.Fatalf("wrong lookup result. got=(%v, %d, %t)", builtin, index, ok)
+	}
+	if builtin.Doc != "double(x) doubles an integer" {
+		t.Errorf("wrong doc. got=%q", builtin.Doc)
+	}
+
+	result := builtin.Fn(&Integer{Value: 21})
+	if result.Inspect() != "42" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+
+	result = builtin.Fn()
+	errObj, ok := result.(*Error)
+	if !ok {
+		t.Fatalf("expected arity error. got=%T (%+v)", result, result)
+	}
+	if errObj.Message != "wrong number of arguments. got=0, want=1" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+
+	replaced := r.Register("double", Variadic, "", func(args ...Object) Object {
+		return &Integer{Value: int64(len(args))}
+	})
+	if _, index, _ := r.Lookup("double"); index != 0 {
+		t.Errorf("replaced builtin moved. got index=%d", index)
+	}
+	if r.Get(0) != replaced || r.Len() != 2 {
+		t.Errorf("builtin was not replaced in place")
+	}
+	if r.Get(2) != nil || r.Get(-1) != nil {
+		t.Errorf("expected nil for out of range index")
+	}
+
+	if _, _, ok := r.Lookup("missing"); ok {
+		t.Errorf("found builtin that was never registered")
+	}
+}
+
+func TestCyclicValues(t *testing.T) {
+	arr := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+	arr.Elements[1] = arr
+	key := &String{Value: "self"}
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: hash}
+	shared := &Array{Elements: []Object{&Integer{Value: 2}}}
+	twice := &Array{Elements: []Object{shared, shared}}
+
+	if arr.Inspect() != "[1, [...]]" {
+		t.Errorf("wrong inspect of a cyclic array. got=%q", arr.Inspect())
+	}
+	if hash.Inspect() != "{self: {...}}" {
+		t.Errorf("wrong inspect of a cyclic hash. got=%q", hash.Inspect())
+	}
+	if twice.Inspect() != "[[2], [2]]" {
+		t.Errorf("wrong inspect of a shared array. got=%q", twice.Inspect())
+	}
+
+	var v interface{}
+	if err := ToGo(arr, &v); err == nil {
+		t.Errorf("expected error converting a cyclic array")
+	}
+	var elements []interface{}
+	if err := ToGo(arr, &elements); err == nil {
+		t.Errorf("expected error converting a cyclic array")
+	}
+	var m map[string]interface{}
+	if err := ToGo(hash, &m); err == nil {
+		t.Errorf("expected error converting a cyclic hash")
+	}
+	if err := ToGo(twice, &v); err != nil {
+		t.Errorf("unexpected error converting a shared array: %s", err)
+	}
+
+	cyclic := []interface{}{1, nil}
+	cyclic[1] = cyclic
+	if _, err := FromGo(cyclic); err == nil {
+		t.Errorf("expected error converting a cyclic slice")
+	}
+	type node struct{ Next *node }
+	n := &node{}
+	n.Next = n
+	if _, err := FromGo(n); err == nil {
+		t.Errorf("expected error converting a cyclic pointer")
+	}
+	s := []int{3}
+	if obj, err := FromGo([][]int{s, s}); err != nil || obj.Inspect() != "[[3], [3]]" {
+		t.Errorf("shared slices must convert. got=%v (%v)", obj, err)
+	}
+}
+
+func TestFloat(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{2, "2.0"},
+		{-0.25, "-0.25"},
+		{1e21, "1000000000000000000000.0"},
+		{1e20, "100000000000000000000.0"},
+		{1e-7, "0.0000001"},
+		{-1.5e-10, "-0.00000000015"},
+		{math.Copysign(0, -1), "-0.0"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).Inspect(); got != tt.expected {
+			t.Errorf("wrong inspect for %v. want=%q, got=%q", tt.value, tt.expected, got)
+		}
+	}
+
+	if (&Float{Value: 3}).HashKey() != (&Integer{Value: 3}).HashKey() {
+		t.Errorf("whole floats must have the hash key of the equal integer")
+	}
+	if (&Float{Value: 0.5}).HashKey() == (&Float{Value: 0.25}).HashKey() {
+		t.Errorf("different floats have the same hash key")
+	}
+	if (&Float{Value: 0.5}).HashKey() != (&Float{Value: 0.5}).HashKey() {
+		t.Errorf("equal floats have different hash keys")
+	}
+}
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -0,0 +1,201 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"monkey/token"
+	"strings"
+)
+
+// Severity describes how bad a diagnostic is. Only errors stop a program
+// from running, warnings are informational.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Code identifies the kind of problem a diagnostic reports. Codes are
+// stable, so tests and tooling can match on them instead of message text.
+type Code string
+
+const (
+	CodeUnexpectedToken    Code = "P0001" // the next token isn't the one the grammar requires
+	CodeNoPrefixParseFn    Code = "P0002" // a token can't start an expression
+	CodeInvalidInteger     Code = "P0003" // an integer literal doesn't fit into an int64
+	CodeUnknownKeyword     Code = "P0004" // an identifier looks like a misspelled keyword
+	CodeInvalidFloat       Code = "P0005" // a float literal is out of the range of a float64
+	CodeIllegalToken       Code = "P0006" // a character or an unterminated comment the lexer doesn't accept
+	CodeInvalidString      Code = "P0007" // a string literal isn't terminated or has an invalid escape sequence
+	CodeOutsideLoop        Code = "P0008" // break or continue outside of a loop
+	CodeInvalidAssignment  Code = "P0009" // the left side of = is neither a name nor an index expression
+	CodeEmptyInterpolation Code = "P0010" // a ${} in a string doesn't contain an expression
+)
+
+// Diagnostic is a single message produced while parsing, located at a span
+// of the source code.
+type Diagnostic struct {
+	Severity Severity
+	Code     Code
+	Message  string
+	Pos      token.Position // first character the diagnostic refers to
+	End      token.Position // position right after the offending span
+	Hints    []string       // optional "did you mean" style suggestions
+}
+
+// Error formats the diagnostic on a single line as file:line:col: message
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+}
+
+// Render writes the diagnostic to w in a human friendly form: a header with
+// the severity and code, the offending line of source and a caret underline
+// marking the span, followed by any hints.
+//
+//	error[P0001]: Expected next token to be ), got = instead
+//	 --> script.mk:1:7
+//	  |
+//	1 | if (x = 5) { x }
+//	  |       ^
+//	  = help: did you mean `==`?
+func (d *Diagnostic) Render(w io.Writer, source string) {
+	var out bytes.Buffer
+
+	fmt.Fprintf(&out, "%s[%s]: %s\n", d.Severity, d.Code, d.Message)
+
+	line, ok := sourceLine(source, d.Pos.Line)
+	if !ok {
+		fmt.Fprintf(&out, " --> %s\n", d.Pos)
+		for _, hint := range d.Hints {
+			fmt.Fprintf(&out, " = help: %s\n", hint)
+		}
+		w.Write(out.Bytes())
+		return
+	}
+
+	lineNo := fmt.Sprintf("%d", d.Pos.Line)
+	gutter := strings.Repeat(" ", len(lineNo))
+
+	fmt.Fprintf(&out, "%s--> %s\n", gutter, d.Pos)
+	fmt.Fprintf(&out, "%s |\n", gutter)
+	fmt.Fprintf(&out, "%s | %s\n", lineNo, line)
+	fmt.Fprintf(&out, "%s | %s\n", gutter, underline(line, d.Pos, d.End))
+	for _, hint := range d.Hints {
+		fmt.Fprintf(&out, "%s = help: %s\n", gutter, hint)
+	}
+
+	w.Write(out.Bytes())
+}
+
+// RenderDiagnostics renders every diagnostic in diags against source
+func RenderDiagnostics(w io.Writer, source string, diags []*Diagnostic) {
+	for _, d := range diags {
+		d.Render(w, source)
+	}
+}
+
+// sourceLine returns the 1 based line n of source without its line ending
+func sourceLine(source string, n int) (string, bool) {
+	if n < 1 {
+		return "", false
+	}
+
+	lines := strings.Split(source, "\n")
+	if n > len(lines) {
+		return "", false
+	}
+
+	return strings.TrimRight(lines[n-1], "\r"), true
+}
+
+// underline builds the caret line marking the span from pos to end. Tabs
+// in front of the span are kept so the carets line up with the source.
+func underline(line string, pos, end token.Position) string {
+	var out bytes.Buffer
+
+	// columns count characters, not bytes
+	chars := []rune(line)
+	start := pos.Column - 1
+	if start > len(chars) {
+		start = len(chars)
+	}
+	for _, ch := range chars[:start] {
+		if ch == '\t' {
+			out.WriteByte('\t')
+		} else {
+			out.WriteByte(' ')
+		}
+	}
+
+	width := 1
+	if end.Line == pos.Line && end.Column > pos.Column {
+		width = end.Column - pos.Column
+	}
+	out.WriteString(strings.Repeat("^", width))
+
+	return out.String()
+}
+
+// closestKeyword looks for a keyword that ident is likely a misspelling of
+func closestKeyword(ident string) (string, bool) {
+	for _, kw := range token.Keywords() {
+		if ident != kw && len(kw) > 2 && editDistance(ident, kw) <= 1 {
+			return kw, true
+		}
+	}
+	return "", false
+}
+
+// editDistance computes the optimal string alignment distance between a
+// and b, which is the Levenshtein distance with transpositions of two
+// adjacent characters counted as a single edit ("retrun" -> "return").
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+
+			transposed := i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1]
+			if transposed && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+
+	return d[len(a)][len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
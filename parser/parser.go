@@ -6,6 +6,7 @@ import (
 	"monkey/lexer"
 	"monkey/token"
 	"strconv"
+	"strings"
 )
 
 // Precendences of the Monkey programming language
@@ -14,6 +15,9 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // =
+	OR          // ||
+	AND         // &&
 	EQUALS      // ==
 	LESSGREATER // > or <
 	SUM         // +
@@ -24,12 +28,19 @@ const (
 )
 
 type Parser struct {
-	l      *lexer.Lexer // to get our token input via NextToken()
-	errors []string
+	l           *lexer.Lexer // to get our token input via NextToken()
+	diagnostics []*Diagnostic
 
 	curToken  token.Token
 	peekToken token.Token
 
+	// panicking is set after an error until the parser has resynchronized
+	// at a statement boundary. Errors found meanwhile are likely caused by
+	// the first one and are dropped.
+	panicking bool
+	depth     int // number of currently open braces, up to curToken
+	loops     int // number of loops around curToken in the current function
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
@@ -37,12 +48,23 @@ type Parser struct {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	// lexers keeping comments for other tools can still be parsed
+	for p.peekToken.Type == token.COMMENT {
+		p.peekToken = p.l.NextToken()
+	}
+
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.depth++
+	case token.RBRACE:
+		p.depth--
+	}
 }
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:           l,
+		diagnostics: []*Diagnostic{},
 	}
 
 	// Read two tokens, so curToken and peekToken are both set
@@ -52,14 +74,17 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.STRING_START, p.parseInterpolatedString)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
@@ -69,10 +94,16 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
@@ -86,14 +117,20 @@ type (
 
 // table associating our precedences with our token representations
 var precedences = map[token.TokenType]int{
+	token.ASSIGN:   ASSIGN,
+	token.OR:       OR,
+	token.AND:      AND,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.LT_EQ:    LESSGREATER,
+	token.GT_EQ:    LESSGREATER,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
+	token.PERCENT:  PRODUCT,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
 }
@@ -141,15 +178,71 @@ func (p *Parser) ParseProgram() *ast.Program {
 	return program
 }
 
+// parseStatement parses a single statement. If the statement contains
+// an error, the parser skips ahead to the start of the next statement,
+// returning either the partially parsed statement or an ast.BadStatement
+// covering the skipped tokens.
 func (p *Parser) parseStatement() ast.Statement {
+	start := p.curToken
+
+	var stmt ast.Statement
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStatement()
+		stmt = p.parseLetStatement()
 	case token.RETURN:
-		return p.parseReturnStatement()
+		stmt = p.parseReturnStatement()
+	case token.WHILE:
+		stmt = p.parseWhileStatement()
+	case token.FOR:
+		stmt = p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		stmt = p.parseBranchStatement()
+	case token.THROW:
+		stmt = p.parseThrowStatement()
 	default:
-		return p.parseExpressionStatement()
+		stmt = p.parseExpressionStatement()
+	}
+
+	if p.panicking {
+		p.synchronize()
+		if stmt == nil {
+			stmt = &ast.BadStatement{Token: start, End: p.curToken.End}
+		}
+	}
+
+	return stmt
+}
+
+// synchronize implements panic mode error recovery. It skips tokens until
+// the current token ends a statement (a ;) or the next one starts a new
+// statement or closes the enclosing block. Braces opened while skipping
+// are skipped over as a whole.
+func (p *Parser) synchronize() {
+	depth := p.depth
+
+	for !p.curTokenIs(token.EOF) && p.depth >= depth {
+		if p.depth == depth && p.atStatementBoundary() {
+			break
+		}
+		p.nextToken()
+	}
+
+	p.panicking = false
+}
+
+// atStatementBoundary reports whether the current token ends a statement
+// or the next token starts a new one or closes the enclosing block
+func (p *Parser) atStatementBoundary() bool {
+	if p.curTokenIs(token.SEMICOLON) {
+		return true
 	}
+
+	switch p.peekToken.Type {
+	case token.LET, token.RETURN, token.FUNCTION, token.RBRACE, token.EOF,
+		token.WHILE, token.FOR, token.BREAK, token.CONTINUE, token.THROW:
+		return true
+	}
+	return false
 }
 
 func (p *Parser) curTokenIs(t token.TokenType) bool {
@@ -160,14 +253,64 @@ func (p *Parser) peekTokenIs(t token.TokenType) bool {
 	return p.peekToken.Type == t
 }
 
-func (p *Parser) Errors() []string {
-	return p.errors
+// Errors returns the diagnostics with error severity. A program with
+// errors must not be evaluated or compiled.
+func (p *Parser) Errors() []*Diagnostic {
+	errors := []*Diagnostic{}
+	for _, d := range p.diagnostics {
+		if d.Severity == SeverityError {
+			errors = append(errors, d)
+		}
+	}
+	return errors
+}
+
+// Diagnostics returns every diagnostic, including warnings, in the order
+// they were found
+func (p *Parser) Diagnostics() []*Diagnostic {
+	return p.diagnostics
+}
+
+// report records a diagnostic covering the span of tok
+func (p *Parser) report(
+	severity Severity,
+	code Code,
+	tok token.Token,
+	format string,
+	a ...interface{},
+) *Diagnostic {
+	d := &Diagnostic{
+		Severity: severity,
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+		Pos:      tok.Pos,
+		End:      tok.End,
+	}
+	if p.panicking {
+		// most likely a consequence of an earlier error, drop it
+		return d
+	}
+	if severity == SeverityError {
+		p.panicking = true
+	}
+
+	p.diagnostics = append(p.diagnostics, d)
+	return d
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("Expected next token to be %s, got %s instead",
-		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	d := p.report(SeverityError, CodeUnexpectedToken, p.peekToken,
+		"Expected next token to be %s, got %s instead", t, p.peekToken.Type)
+
+	switch {
+	case t == token.IDENT && token.LookupIdent(p.peekToken.Literal) != token.IDENT:
+		d.Hints = append(d.Hints, fmt.Sprintf(
+			"`%s` is a keyword and can't be used as a name", p.peekToken.Literal))
+	case t == token.ASSIGN && p.peekTokenIs(token.EQ):
+		d.Hints = append(d.Hints, "did you mean `=`?")
+	case t != token.IDENT && p.peekTokenIs(token.ASSIGN):
+		d.Hints = append(d.Hints, "did you mean `==`?")
+	}
 }
 
 // expectPeek checks if the Peek token is of type t and if so
@@ -182,7 +325,7 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	}
 }
 
-func (p *Parser) parseLetStatement() *ast.LetStatement {
+func (p *Parser) parseLetStatement() ast.Statement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 
 	if !p.expectPeek(token.IDENT) {
@@ -199,6 +342,11 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
+	// the function can refer to itself by this name, even when it's local
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -212,18 +360,110 @@ func (p *Parser) parseReturnStatement() ast.Statement {
 
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 	return stmt
 
 }
 
+func (p *Parser) parseThrowStatement() ast.Statement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseCondition()
+
+	if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+	return stmt
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) || !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loops++
+	body := p.parseBlockStatement()
+	p.loops--
+
+	// like expression statements, loops may end with a semicolon
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return body
+}
+
+// parseBranchStatement parses break and continue, which are only allowed
+// inside of a loop of the current function
+func (p *Parser) parseBranchStatement() ast.Statement {
+	stmt := &ast.BranchStatement{Token: p.curToken}
+
+	if p.loops == 0 {
+		p.report(SeverityError, CodeOutsideLoop, p.curToken,
+			"%s outside of a loop", p.curToken.Literal)
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
 	stmt.Expression = p.parseExpression(LOWEST)
 
+	// a lone identifier directly followed by another one is most likely a
+	// misspelled keyword, e.g. `retrun x;`
+	if ident, ok := stmt.Expression.(*ast.Identifier); ok && p.peekTokenIs(token.IDENT) {
+		if kw, ok := closestKeyword(ident.Value); ok {
+			d := p.report(SeverityWarning, CodeUnknownKeyword, ident.Token,
+				"unknown identifier %s followed by %s", ident.Value,
+				p.peekToken.Literal)
+			d.Hints = append(d.Hints, fmt.Sprintf("did you mean `%s`?", kw))
+		}
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -231,12 +471,21 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
+	start := p.curToken
+
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		p.noPrefixParseFnError(p.curToken.Type)
-		return nil
+		if p.curTokenIs(token.ILLEGAL) {
+			p.illegalTokenError()
+		} else {
+			p.noPrefixParseFnError(p.curToken.Type)
+		}
+		return &ast.BadExpression{Token: start}
 	}
 	leftExp := prefix()
+	if leftExp == nil {
+		return &ast.BadExpression{Token: start}
+	}
 
 	// while the next token isn't a semicolon && doesn't have higher precedence
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
@@ -248,6 +497,9 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		p.nextToken()
 
 		leftExp = infix(leftExp)
+		if leftExp == nil {
+			return &ast.BadExpression{Token: start}
+		}
 	}
 
 	return leftExp
@@ -262,8 +514,8 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.report(SeverityError, CodeInvalidInteger, p.curToken,
+			"could not parse %q as integer", p.curToken.Literal)
 		return nil
 	}
 
@@ -272,9 +524,38 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return lit
 }
 
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.report(SeverityError, CodeInvalidFloat, p.curToken,
+			"could not parse %q as float", p.curToken.Literal)
+		return nil
+	}
+
+	return &ast.FloatLiteral{Token: p.curToken, Value: value}
+}
+
+// illegalTokenError explains why the lexer didn't accept the current token
+func (p *Parser) illegalTokenError() {
+	literal := p.curToken.Literal
+
+	switch {
+	case strings.HasPrefix(literal, `"`):
+		_, err := lexer.Unquote(literal)
+		if err == nil {
+			err = fmt.Errorf("invalid string literal")
+		}
+		p.report(SeverityError, CodeInvalidString, p.curToken, "%s", err)
+	case strings.HasPrefix(literal, "/*"):
+		p.report(SeverityError, CodeIllegalToken, p.curToken, "comment not terminated")
+	default:
+		p.report(SeverityError, CodeIllegalToken, p.curToken, "illegal character %q", literal)
+	}
+}
+
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.report(SeverityError, CodeNoPrefixParseFn, p.curToken,
+		"no prefix parse function for %s found", t)
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
@@ -304,6 +585,25 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// parseAssignExpression parses an assignment to a variable, an array
+// element or a hash key. Assignments are right associative, `a = b = 1`
+// assigns 1 to both.
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{Token: p.curToken, Target: left}
+
+	switch left.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		p.report(SeverityError, CodeInvalidAssignment, p.curToken,
+			"cannot assign to %s", left.String())
+	}
+
+	p.nextToken()
+	expression.Value = p.parseExpression(ASSIGN - 1)
+
+	return expression
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
@@ -323,20 +623,86 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
+	depth := p.depth
+	recovered := false
 
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		errors := len(p.diagnostics)
 		stmt := p.parseStatement()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
+		recovered = len(p.diagnostics) != errors
+
+		// a broken statement may have swallowed our closing brace
+		if p.depth < depth {
+			return block
+		}
 		p.nextToken()
 	}
 
+	if p.curTokenIs(token.EOF) && !recovered {
+		p.report(SeverityError, CodeUnexpectedToken, p.curToken,
+			"Expected next token to be %s, got %s instead", token.RBRACE, token.EOF)
+	}
+
 	return block
 }
 
+// parseTryExpression parses `try { } catch (e) { } finally { }`, either
+// the catch or the finally block may be left out
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Block = p.parseBlockStatement()
+
+	if !p.peekTokenIs(token.CATCH) && !p.peekTokenIs(token.FINALLY) {
+		p.peekError(token.CATCH)
+		return nil
+	}
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if !p.expectPeek(token.LPAREN) || !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		expression.Parameter = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+		if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	return expression
+}
+
+// parseCondition parses the condition of an if expression or a while loop.
+// An assignment there most likely is a comparison with a missing `=`.
+func (p *Parser) parseCondition() ast.Expression {
+	condition := p.parseExpression(LOWEST)
+
+	if assign, ok := condition.(*ast.AssignExpression); ok {
+		d := p.report(SeverityError, CodeUnexpectedToken, assign.Token,
+			"assignment used as a condition")
+		d.Hints = append(d.Hints, "did you mean `==`?")
+	}
+	return condition
+}
+
 func (p *Parser) parseIfExpression() ast.Expression {
 	expression := &ast.IfExpression{Token: p.curToken}
 
@@ -345,9 +711,11 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	}
 
 	p.nextToken()
-	expression.Condition = p.parseExpression(LOWEST)
+	expression.Condition = p.parseCondition()
 
-	if !p.expectPeek(token.RPAREN) {
+	// a missing ) in front of the consequence is a common slip, report it
+	// but keep parsing as if it was there
+	if !p.expectPeek(token.RPAREN) && !p.peekTokenIs(token.LBRACE) {
 		return nil
 	}
 
@@ -383,7 +751,11 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
+	// loops around the function can't be left from inside of it
+	loops := p.loops
+	p.loops = 0
 	lit.Body = p.parseBlockStatement()
+	p.loops = loops
 
 	return lit
 }
@@ -401,7 +773,10 @@ func (p *Parser) parseMacroLiteral() ast.Expression {
 		return nil
 	}
 
+	loops := p.loops
+	p.loops = 0
 	lit.Body = p.parseBlockStatement()
+	p.loops = loops
 
 	return lit
 }
@@ -443,6 +818,54 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// parseInterpolatedString parses the parts of a string with embedded
+// expressions, the text between them is left out if it is empty
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.curToken}
+
+	for {
+		if p.curToken.Literal != "" {
+			str.Parts = append(str.Parts, &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+		}
+		if p.curTokenIs(token.STRING_END) {
+			return str
+		}
+
+		part := p.curToken
+		p.nextToken()
+		if p.curTokenIs(token.STRING_MIDDLE) || p.curTokenIs(token.STRING_END) {
+			p.emptyInterpolationError(part, p.curToken)
+			return nil
+		}
+		str.Parts = append(str.Parts, p.parseExpression(LOWEST))
+
+		p.nextToken()
+		switch {
+		case p.curTokenIs(token.ILLEGAL):
+			// the rest of the string is invalid
+			p.illegalTokenError()
+			return nil
+		case !p.curTokenIs(token.STRING_MIDDLE) && !p.curTokenIs(token.STRING_END):
+			p.report(SeverityError, CodeUnexpectedToken, p.curToken,
+				"expected } after the expression embedded in the string, got %s", p.curToken.Type)
+			return nil
+		}
+	}
+}
+
+// emptyInterpolationError reports the ${ ending the string part before and
+// the } starting the one after as an empty embedded expression
+func (p *Parser) emptyInterpolationError(before, after token.Token) {
+	open := before.End
+	open.Offset -= len("${")
+	open.Column -= len("${")
+
+	tok := token.Token{Pos: open, End: after.Pos}
+	tok.End.Offset++
+	tok.End.Column++
+	p.report(SeverityError, CodeEmptyInterpolation, tok, "empty expression in string interpolation")
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 
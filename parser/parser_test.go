@@ -1,12 +1,449 @@
 package parser
 
 import (
+	"bytes"
 	"fmt"
 	"monkey/ast"
 	"monkey/lexer"
 	"testing"
 )
 
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      string
+		expectedParts int
+	}{
+		{`"total: ${a + b}"`, "total: ${(a + b)}", 2},
+		{`"${x}"`, "${x}", 1},
+		{`"${x}${y}"`, "${x}${y}", 2},
+		{`"a ${f(1, "b ${c}")} d"`, "a ${f(1, b ${c})} d", 3},
+		{`"${ {"k": 1}["k"] }!"`, "${({k:1}[k])}!", 2},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("input %q: not an *ast.ExpressionStatement. got=%T",
+				tt.input, program.Statements[0])
+		}
+		str, ok := stmt.Expression.(*ast.InterpolatedString)
+		if !ok {
+			t.Fatalf("input %q: not an *ast.InterpolatedString. got=%T",
+				tt.input, stmt.Expression)
+		}
+
+		if str.String() != tt.expected {
+			t.Errorf("input %q: wrong string. want=%q, got=%q",
+				tt.input, tt.expected, str.String())
+		}
+		if len(str.Parts) != tt.expectedParts {
+			t.Errorf("input %q: wrong number of parts. want=%d, got=%d",
+				tt.input, tt.expectedParts, len(str.Parts))
+		}
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"throw 1 + 2;", "throw (1 + 2);"},
+		{`throw "boom"`, "throw boom;"},
+		{"try { f() } catch (e) { e }", "try f() catch (e) e"},
+		{"try { f() } finally { g() }", "try f() finally g()"},
+		{"try { 1 } catch (e) { 2 } finally { 3 }", "try 1 catch (e) 2 finally 3"},
+		{"let x = try { 1 } catch (e) { 2 }; x", "let x = try 1 catch (e) 2;x"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("input %q: wrong program. want=%q, got=%q",
+				tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5;", "(x = 5)"},
+		{"x = y = 1 + 2", "(x = (y = (1 + 2)))"},
+		{"a[0] = b || c", "((a[0]) = (b || c))"},
+		{`h["k"][1] = -x`, `(((h[k])[1]) = (-x))`},
+		{"f(x = 1)", "f((x = 1))"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("input %q: wrong program. want=%q, got=%q",
+				tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < 3) { x }", "while(x < 3) x"},
+		{"while (x) { x }; x", "whilex xx"},
+		{"while (true) { break; continue }", "whiletrue break;continue;"},
+		{"for (x in [1, 2]) { puts(x); }", "for (x in [1, 2]) puts(x)"},
+		{"for (c in s) { if (c) { break } }", "for (c in s) ifc break;"},
+		{"while (a) { let f = fn() { 1 }; }", "whilea let f = fn() 1;"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("input %q: wrong program. want=%q, got=%q",
+				tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestInvalidStringMessages(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"abc`, "1:1: string literal not terminated"},
+		{`x + "abc\"`, "1:5: string literal not terminated"},
+		{`"a\qb"`, `1:1: invalid escape sequence \q`},
+		{`"\u12"`, `1:1: invalid escape sequence \u12: want 4 hex digits`},
+		{`"\ud800"`, `1:1: invalid escape sequence \ud800`},
+		{`"ä" + €`, `1:7: illegal character "€"`},
+		{`"a ${1} \q"`, `1:7: invalid escape sequence \q`},
+		{`"a ${1}`, "1:7: string literal not terminated"},
+		{`"a ${1 2}"`, "1:8: expected } after the expression embedded in the string, got INT"},
+		{`"a ${ }"`, "1:4: empty expression in string interpolation"},
+		{`"${1} and ${}"`, "1:11: empty expression in string interpolation"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("input %q: expected errors, got none", tt.input)
+			continue
+		}
+		if errors[0].Error() != tt.expected {
+			t.Errorf("input %q: wrong error. want=%q, got=%q",
+				tt.input, tt.expected, errors[0].Error())
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// adds two numbers
+let add = fn(a, /* first */ b) {
+  a + b // the sum
+};`
+
+	for _, keepComments := range []bool{false, true} {
+		l := lexer.New(input)
+		l.SetKeepComments(keepComments)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		expected := "let add = fn(a, b) (a + b);"
+		if program.String() != expected {
+			t.Errorf("keepComments=%t: wrong program. want=%q, got=%q",
+				keepComments, expected, program.String())
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     int
+		expectedStatements []string
+	}{
+		{
+			"let x = ;\nlet y = 10;\nlet = 5;\nlet z = y + 1;",
+			2,
+			[]string{
+				"let x = <bad expression>;",
+				"let y = 10;",
+				"<bad statement>",
+				"let z = (y + 1);",
+			},
+		},
+		{
+			"let f = fn(x) {\n  if (x > 1 { x }\n};\nlet y = 2;",
+			1,
+			[]string{
+				"let f = fn(x) if(x > 1) x;",
+				"let y = 2;",
+			},
+		},
+		{
+			"let f = fn(x) { let = 1; x };\nf(1);",
+			1,
+			[]string{
+				"let f = fn(x) <bad statement>x;",
+				"f(1)",
+			},
+		},
+		{
+			"fn() { 1 + }; 5;",
+			1,
+			[]string{
+				"fn() (1 + <bad expression>)",
+				"5",
+			},
+		},
+		{
+			"let f = fn(x) { x + 1;\nlet y = 2;",
+			1,
+			[]string{
+				"let f = fn(x) (x + 1)let y = 2;;",
+			},
+		},
+		{
+			"return 5",
+			0,
+			[]string{"return 5;"},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		if len(p.Errors()) != tt.expectedErrors {
+			t.Errorf("input %q: wrong number of errors. want=%d, got=%d (%v)",
+				tt.input, tt.expectedErrors, len(p.Errors()), p.Errors())
+		}
+
+		if len(program.Statements) != len(tt.expectedStatements) {
+			t.Errorf("input %q: wrong number of statements. want=%d, got=%d (%q)",
+				tt.input, len(tt.expectedStatements), len(program.Statements),
+				program.String())
+			continue
+		}
+
+		for i, stmt := range program.Statements {
+			if stmt.String() != tt.expectedStatements[i] {
+				t.Errorf("input %q: statement %d wrong. want=%q, got=%q",
+					tt.input, i, tt.expectedStatements[i], stmt.String())
+			}
+		}
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(x, y) {
+  x + y;
+};
+add(1, 2)[0];`
+
+	l := lexer.NewWithFilename(input, "test.mk")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	let := program.Statements[0].(*ast.LetStatement)
+	fn := let.Value.(*ast.FunctionLiteral)
+	body := fn.Body.Statements[0].(*ast.ExpressionStatement)
+	index := program.Statements[1].(*ast.ExpressionStatement).Expression
+
+	tests := []struct {
+		node           ast.Node
+		expectedLine   int
+		expectedColumn int
+	}{
+		{program, 1, 1},
+		{let, 1, 1},
+		{let.Name, 1, 5},
+		{fn, 1, 11},
+		{fn.Parameters[1], 1, 17},
+		{body.Expression, 2, 3},
+		{body.Expression.(*ast.InfixExpression).Right, 2, 7},
+		{index, 4, 1},
+		{index.(*ast.IndexExpression).Index, 4, 11},
+	}
+
+	for i, tt := range tests {
+		pos := tt.node.Pos()
+		if pos.Filename != "test.mk" {
+			t.Errorf("tests[%d] - filename wrong. got=%q", i, pos.Filename)
+		}
+		if pos.Line != tt.expectedLine || pos.Column != tt.expectedColumn {
+			t.Errorf("tests[%d] - %q position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.node.String(), tt.expectedLine, tt.expectedColumn,
+				pos.Line, pos.Column)
+		}
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	input := `let x = 5;
+let = 10;`
+
+	l := lexer.NewWithFilename(input, "test.mk")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors, got none")
+	}
+
+	expected := "test.mk:2:5: Expected next token to be IDENT, got = instead"
+	if errors[0].Error() != expected {
+		t.Errorf("wrong error. expected=%q, got=%q", expected, errors[0].Error())
+	}
+}
+
+func TestDiagnosticCodes(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedCode     Code
+		expectedSeverity Severity
+		expectedHint     string
+	}{
+		{"let = 5;", CodeUnexpectedToken, SeverityError, ""},
+		{"let fn = 5;", CodeUnexpectedToken, SeverityError,
+			"`fn` is a keyword and can't be used as a name"},
+		{"let x == 5;", CodeUnexpectedToken, SeverityError, "did you mean `=`?"},
+		{"if (x = 5) { x }", CodeUnexpectedToken, SeverityError, "did you mean `==`?"},
+		{"5 + ;", CodeNoPrefixParseFn, SeverityError, ""},
+		{"99999999999999999999;", CodeInvalidInteger, SeverityError, ""},
+		{"retrun x;", CodeUnknownKeyword, SeverityWarning, "did you mean `return`?"},
+		{"let x = 5 @ 2;", CodeIllegalToken, SeverityError, ""},
+		{"let x = 5; /* never closed", CodeIllegalToken, SeverityError, ""},
+		{`let s = "never closed;`, CodeInvalidString, SeverityError, ""},
+		{`let s = "bad \q";`, CodeInvalidString, SeverityError, ""},
+		{"break;", CodeOutsideLoop, SeverityError, ""},
+		{"while (true) { fn() { continue; } }", CodeOutsideLoop, SeverityError, ""},
+		{"1 = 2;", CodeInvalidAssignment, SeverityError, ""},
+		{"f() = 2;", CodeInvalidAssignment, SeverityError, ""},
+		{"while (x = 5) { x }", CodeUnexpectedToken, SeverityError, "did you mean `==`?"},
+		{"try { 1 }", CodeUnexpectedToken, SeverityError, ""},
+		{"try { 1 } catch (1) { 2 }", CodeUnexpectedToken, SeverityError, ""},
+		{"throw;", CodeNoPrefixParseFn, SeverityError, ""},
+		{`"${ }"`, CodeEmptyInterpolation, SeverityError, ""},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		diags := p.Diagnostics()
+		if len(diags) == 0 {
+			t.Errorf("input %q: expected diagnostics, got none", tt.input)
+			continue
+		}
+
+		d := diags[0]
+		if d.Code != tt.expectedCode {
+			t.Errorf("input %q: wrong code. expected=%s, got=%s",
+				tt.input, tt.expectedCode, d.Code)
+		}
+		if d.Severity != tt.expectedSeverity {
+			t.Errorf("input %q: wrong severity. expected=%s, got=%s",
+				tt.input, tt.expectedSeverity, d.Severity)
+		}
+		if tt.expectedHint != "" && (len(d.Hints) == 0 || d.Hints[0] != tt.expectedHint) {
+			t.Errorf("input %q: wrong hints. expected=%q, got=%q",
+				tt.input, tt.expectedHint, d.Hints)
+		}
+	}
+}
+
+func TestWarningsAreNotErrors(t *testing.T) {
+	l := lexer.New("retrun x;")
+	p := New(l)
+	p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Errorf("expected no errors, got=%d", len(p.Errors()))
+	}
+	if len(p.Diagnostics()) != 1 {
+		t.Errorf("expected 1 diagnostic, got=%d", len(p.Diagnostics()))
+	}
+}
+
+func TestRenderDiagnostic(t *testing.T) {
+	input := "let x = 1;\nif (x = 5) { x }"
+
+	l := lexer.NewWithFilename(input, "test.mk")
+	p := New(l)
+	p.ParseProgram()
+
+	var out bytes.Buffer
+	RenderDiagnostics(&out, input, p.Errors()[:1])
+
+	expected := `error[P0001]: assignment used as a condition
+ --> test.mk:2:7
+  |
+2 | if (x = 5) { x }
+  |       ^
+  = help: did you mean ` + "`==`?" + `
+`
+	if out.String() != expected {
+		t.Errorf("wrong rendering.\nexpected=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. got=%T",
+			program.Statements[0])
+	}
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.FunctionLiteral. got=%T",
+			stmt.Value)
+	}
+
+	if function.Name != "myFunction" {
+		t.Fatalf("function literal name wrong. want 'myFunction', got=%q\n",
+			function.Name)
+	}
+}
+
 func TestMacroLiteralParsing(t *testing.T) {
 	input := `macro(x,y) {x + y; }`
 
@@ -548,6 +985,11 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"5 < 5;", 5, "<", 5},
 		{"5 == 5;", 5, "==", 5},
 		{"5 != 5;", 5, "!=", 5},
+		{"5 % 5;", 5, "%", 5},
+		{"5 <= 5;", 5, "<=", 5},
+		{"5 >= 5;", 5, ">=", 5},
+		{"true && false", true, "&&", false},
+		{"false || true", false, "||", true},
 		{"foobar + barfoo;", "foobar", "+", "barfoo"},
 		{"foobar - barfoo;", "foobar", "-", "barfoo"},
 		{"foobar * barfoo;", "foobar", "*", "barfoo"},
@@ -627,6 +1069,22 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		input    string
 		expected string
 	}{
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
 		{
 			"a + add(b * c) + d",
 			"((a + add((b * c))) + d)",
@@ -747,6 +1205,37 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	input := "2.5 * -0.125;"
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	infix, ok := stmt.Expression.(*ast.InfixExpression)
+	if !ok {
+		t.Fatalf("expression not *ast.InfixExpression. got=%T", stmt.Expression)
+	}
+
+	literal, ok := infix.Left.(*ast.FloatLiteral)
+	if !ok {
+		t.Fatalf("left not *ast.FloatLiteral. got=%T", infix.Left)
+	}
+	if literal.Value != 2.5 {
+		t.Errorf("literal.Value not %g. got=%g", 2.5, literal.Value)
+	}
+	if literal.TokenLiteral() != "2.5" {
+		t.Errorf("literal.TokenLiteral not %s. got=%s", "2.5", literal.TokenLiteral())
+	}
+
+	if program.String() != "(2.5 * (-0.125))" {
+		t.Errorf("wrong program. got=%s", program.String())
+	}
+}
+
 func TestIntegerLiteralExpression(t *testing.T) {
 	input := "5;"
 
@@ -4,70 +4,66 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"monkey/compiler"
-	"monkey/lexer"
+	"monkey"
 	"monkey/object"
-	"monkey/parser"
-	"monkey/vm"
 )
 
 const PROMPT = ">> "
 
+// Start reads lines from in and runs each of them on the VM, printing the
+// results to out. Every line is run by the same interpreter, so globals and
+// macros defined by one line are visible to the next.
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 
-	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	interp := monkey.New(monkey.EngineVM)
+	interp.Stdout = out
+	interp.Stderr = out
 
 	for {
-		fmt.Printf(PROMPT)
+		fmt.Fprint(out, PROMPT)
 		scanned := scanner.Scan()
 		if !scanned {
 			return
 		}
 
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
-
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
-			continue
-		}
-
-		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(program)
+		result, err := interp.Eval(scanner.Text())
 		if err != nil {
-			fmt.Fprintf(out, "Compilation failed:\n %s\n", err)
+			printError(out, err)
 			continue
 		}
 
-		code := comp.ByteCode()
-		print(code.Instructions.String())
-		constants = code.Constants
-
-		machine := vm.NewWithGlobalsStore(code, globals)
-		err = machine.Run()
-		if err != nil {
-			fmt.Fprintf(out, "Executing bytecode failed:\n %s\n", err)
+		// nothing is printed for lines like let statements and macro
+		// definitions, which don't produce a value
+		if result.Type() == object.NULL_OBJ {
 			continue
 		}
-
-		stackTop := machine.LastPoppedStackElem()
-		io.WriteString(out, stackTop.Inspect())
+		io.WriteString(out, result.Inspect())
 		io.WriteString(out, "\n")
 	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
-	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+// printError prints an error of the interpreter. Runtime errors raised
+// inside of a function come with the Monkey call stack.
+func printError(out io.Writer, err error) {
+	switch err := err.(type) {
+	case *monkey.SyntaxError:
+		io.WriteString(out, "Woops! We ran into some monkey business here!\n")
+		err.Render(out)
+	case *monkey.CompileError:
+		fmt.Fprintf(out, "Compilation failed:\n %s\n", err.Err)
+	case *monkey.RuntimeError:
+		if err.Pos.IsValid() {
+			fmt.Fprintf(out, "Executing bytecode failed:\n %s: %s\n", err.Pos, err.Message)
+		} else {
+			fmt.Fprintf(out, "Executing bytecode failed:\n %s\n", err.Message)
+		}
+
+		if len(err.Stack) > 1 {
+			io.WriteString(out, "Stack trace:\n")
+			io.WriteString(out, err.Stack.String())
+		}
+	default:
+		fmt.Fprintf(out, "%s\n", err)
 	}
 }
@@ -1,5 +1,10 @@
 package token
 
+import (
+	"fmt"
+	"sort"
+)
+
 const (
 	// ILLEGAL describes a Token or character we don't about.
 	ILLEGAL = "ILLEGAL"
@@ -12,8 +17,20 @@ const (
 	IDENT = "IDENT"
 	// INT represents integers
 	INT = "INT"
+	// FLOAT represents floating point numbers with a fraction, ex. 1.5
+	FLOAT = "FLOAT"
 
 	STRING = "STRING"
+	// STRING_START, STRING_MIDDLE and STRING_END hold the text of a string
+	// with embedded ${} expressions: before the first expression, between
+	// two of them and after the last one
+	STRING_START  = "STRING_START"
+	STRING_MIDDLE = "STRING_MIDDLE"
+	STRING_END    = "STRING_END"
+
+	// COMMENT is a // line or /* block */ comment, only returned by lexers
+	// that keep comments
+	COMMENT = "COMMENT"
 
 	// OPERATORS
 	ASSIGN   = "="
@@ -22,12 +39,18 @@ const (
 	MINUS    = "-"
 	SLASH    = "/"
 	ASTERISK = "*"
+	PERCENT  = "%"
 
 	GT     = ">"
 	LT     = "<"
+	GT_EQ  = ">="
+	LT_EQ  = "<="
 	EQ     = "=="
 	NOT_EQ = "!="
 
+	AND = "&&"
+	OR  = "||"
+
 	// DELIMITERS
 	COMMA     = ","
 	COLON     = ":"
@@ -49,6 +72,15 @@ const (
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
 	MACRO    = "MACRO"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	THROW    = "THROW"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
 )
 
 type TokenType string
@@ -56,17 +88,66 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // position of the first character of the token
+	End     Position // position immediately after the token
+}
+
+// Position describes a location in Monkey source code. Lines and columns
+// start at 1, the zero value is an invalid position.
+type Position struct {
+	Filename string
+	Offset   int // byte offset, starting at 0
+	Line     int
+	Column   int // counted in characters, not bytes
+}
+
+// IsValid reports whether the position has been set by the lexer
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String formats the position as file:line:column, leaving out the parts
+// that are unknown
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
 }
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"macro":  MACRO,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"macro":    MACRO,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"throw":    THROW,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+}
+
+// Keywords returns the reserved words of Monkey in alphabetical order
+func Keywords() []string {
+	words := make([]string, 0, len(keywords))
+	for word := range keywords {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return words
 }
 
 func LookupIdent(ident string) TokenType {
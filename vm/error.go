@@ -0,0 +1,99 @@
+package vm
+
+import (
+	"monkey/object"
+	"monkey/token"
+)
+
+// RuntimeError is returned by Run when executing the bytecode fails
+type RuntimeError struct {
+	Message string
+	Pos     token.Position    // position of the failing instruction, if known
+	Stack   object.StackTrace // the active calls, innermost first
+}
+
+func (e *RuntimeError) Error() string { return e.Message }
+
+// runtimeError creates a *RuntimeError for the instruction the VM is at
+func (vm *VM) runtimeError(message string) *RuntimeError {
+	err := &RuntimeError{Message: message, Stack: vm.stackTrace()}
+	if vm.framesIndex > 0 {
+		err.Pos = vm.currentFrame().Pos()
+	}
+	return err
+}
+
+// stackTrace builds the Monkey call stack from the active frames, using the
+// source maps to find out where each frame was
+func (vm *VM) stackTrace() object.StackTrace {
+	trace := object.StackTrace{}
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+
+		name := frame.cl.Fn.Name
+		if i == 0 {
+			name = object.MainFunction
+		}
+
+		var callSite token.Position
+		if i > 0 {
+			callSite = vm.frames[i-1].Pos()
+		}
+
+		trace = append(trace, object.StackFrame{
+			Function: name,
+			Pos:      frame.Pos(),
+			CallSite: callSite,
+		})
+	}
+	return trace
+}
+
+// thrown is returned by run for an exception thrown by the program itself,
+// other errors are turned into exceptions by exception
+type thrown struct {
+	exc *object.Error
+}
+
+func (t *thrown) Error() string { return t.exc.Message }
+
+// newException creates the exception thrown at the instruction the VM is at
+func (vm *VM) newException(message string, value object.Object) *object.Error {
+	exc := &object.Error{Message: message, Value: value, Stack: vm.stackTrace()}
+	if vm.framesIndex > 0 {
+		exc.Pos = vm.currentFrame().Pos()
+	}
+	return exc
+}
+
+// exception returns the exception err is thrown as
+func (vm *VM) exception(err error) *object.Error {
+	if t, ok := err.(*thrown); ok {
+		return t.exc
+	}
+	return vm.newException(err.Error(), nil)
+}
+
+// handle unwinds the frames up to the innermost handler covering the
+// instruction exc was thrown at and continues execution there. It reports
+// whether there was such a handler, the frames are left alone otherwise.
+func (vm *VM) handle(exc *object.Error) bool {
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		h, ok := frame.cl.Fn.Handlers.Lookup(frame.ip)
+		if !ok {
+			continue
+		}
+
+		vm.framesIndex = i + 1
+		vm.sp = frame.basePointer + frame.cl.Fn.NumLocals + h.Depth
+		frame.ip = h.Target - 1
+
+		var value object.Object = exc
+		if !h.Finally {
+			value = exc.Exception()
+		}
+		return vm.push(value) == nil
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package vm
 import (
 	"monkey/code"
 	"monkey/object"
+	"monkey/token"
 )
 
 type Frame struct {
@@ -23,3 +24,9 @@ func NewFrame(cl *object.Closure, basePointer int) *Frame {
 func (f *Frame) Instructions() code.Instructions {
 	return f.cl.Fn.Instructions
 }
+
+// Pos returns the source position of the instruction the frame is at
+func (f *Frame) Pos() token.Position {
+	pos, _ := f.cl.Fn.SourceMap.Lookup(f.ip)
+	return pos
+}
@@ -0,0 +1,381 @@
+package vm
+
+import (
+	"fmt"
+	"monkey/code"
+	"monkey/object"
+)
+
+// VerifyError is returned by Verify for bytecode the VM can't run safely
+type VerifyError struct {
+	Constant int    // index of the function in the constant pool, -1 for the main program
+	Function string // name of the function, if it has one
+	Offset   int    // offset of the offending instruction
+	Message  string
+}
+
+func (e *VerifyError) Error() string {
+	where := object.MainFunction
+	if e.Constant >= 0 {
+		where = fmt.Sprintf("function %d", e.Constant)
+		if e.Function != "" {
+			where += " (" + e.Function + ")"
+		}
+	}
+	return fmt.Sprintf("invalid bytecode: %s, offset %04d: %s", where, e.Offset, e.Message)
+}
+
+// Verify checks the bytecode the VM was created with before it is run.
+// Run trusts its input, bytecode that wasn't produced by the compiler of
+// this version, like bytecode read from a file, must be verified first.
+//
+// The main program and every compiled function in the constant pool are
+// checked for undefined opcodes, truncated instructions, operands that are
+// out of the bounds of the constant pool, the globals, the builtins, the
+// locals and free variables of the function, jumps that don't land on an
+// instruction and instructions that would pop from an empty stack or leave
+// a different number of values on it depending on the path taken. The
+// exception handlers must cover whole instructions and find the stack
+// holding at least the values they keep.
+// Functions must return instead of running past their last instruction,
+// the main program may run until there or halt with OpReturnValue.
+func (vm *VM) Verify() error {
+	v := &verifier{
+		constants:   vm.constants,
+		numGlobals:  len(vm.globals),
+		numBuiltins: vm.builtins.Len(),
+		numFree:     map[int]int{},
+	}
+
+	// decode everything first, the closures created by any function tell
+	// us how many free variables the functions they refer to have
+	main := &verifiedFunction{index: -1, fn: vm.frames[0].cl.Fn}
+	functions := []*verifiedFunction{main}
+	for i, c := range vm.constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			functions = append(functions, &verifiedFunction{index: i, fn: fn})
+		}
+	}
+
+	for _, f := range functions {
+		if err := v.decode(f); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range functions {
+		if err := v.checkOperands(f); err != nil {
+			return err
+		}
+		if err := v.checkHandlers(f); err != nil {
+			return err
+		}
+		if err := v.checkStack(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type verifier struct {
+	constants   []object.Object
+	numGlobals  int
+	numBuiltins int
+	numFree     map[int]int // free variables of the closed over functions, by constant index
+}
+
+// verifiedFunction is the main program or a compiled function being verified
+type verifiedFunction struct {
+	index        int // in the constant pool, -1 for the main program
+	fn           *object.CompiledFunction
+	instructions map[int]*verifiedInstruction // by offset
+	offsets      []int                        // of the instructions, in order
+}
+
+type verifiedInstruction struct {
+	op       code.Opcode
+	def      *code.Definition
+	operands []int
+	next     int // offset of the following instruction
+}
+
+func (f *verifiedFunction) errorf(offset int, format string, a ...interface{}) error {
+	return &VerifyError{
+		Constant: f.index,
+		Function: f.fn.Name,
+		Offset:   offset,
+		Message:  fmt.Sprintf(format, a...),
+	}
+}
+
+// decode splits the instructions of f and records the closures it creates
+func (v *verifier) decode(f *verifiedFunction) error {
+	ins := f.fn.Instructions
+	f.instructions = map[int]*verifiedInstruction{}
+
+	for offset := 0; offset < len(ins); {
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			return f.errorf(offset, "%s", err)
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if offset+1+width > len(ins) {
+			return f.errorf(offset, "%s is missing operands", def.Name)
+		}
+
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+		in := &verifiedInstruction{
+			op:       code.Opcode(ins[offset]),
+			def:      def,
+			operands: operands,
+			next:     offset + 1 + read,
+		}
+		f.instructions[offset] = in
+		f.offsets = append(f.offsets, offset)
+
+		if in.op == code.OpClosure {
+			numFree, ok := v.numFree[operands[0]]
+			if !ok || operands[1] < numFree {
+				v.numFree[operands[0]] = operands[1]
+			}
+		}
+
+		offset = in.next
+	}
+
+	return nil
+}
+
+// checkOperands makes sure every operand refers to something that exists
+func (v *verifier) checkOperands(f *verifiedFunction) error {
+	for _, offset := range f.offsets {
+		in := f.instructions[offset]
+
+		switch in.op {
+		case code.OpConstant:
+			if in.operands[0] >= len(v.constants) {
+				return f.errorf(offset, "constant %d out of range, the pool holds %d",
+					in.operands[0], len(v.constants))
+			}
+
+		case code.OpClosure:
+			if in.operands[0] >= len(v.constants) {
+				return f.errorf(offset, "constant %d out of range, the pool holds %d",
+					in.operands[0], len(v.constants))
+			}
+			if _, ok := v.constants[in.operands[0]].(*object.CompiledFunction); !ok {
+				return f.errorf(offset, "constant %d is not a function: %s",
+					in.operands[0], v.constants[in.operands[0]].Type())
+			}
+
+		case code.OpGetGlobal, code.OpSetGlobal:
+			if in.operands[0] >= v.numGlobals {
+				return f.errorf(offset, "global %d out of range, there are %d",
+					in.operands[0], v.numGlobals)
+			}
+
+		case code.OpGetLocal, code.OpSetLocal, code.OpGetLocalCell:
+			if in.operands[0] >= f.fn.NumLocals {
+				return f.errorf(offset, "local %d out of range, the function has %d",
+					in.operands[0], f.fn.NumLocals)
+			}
+
+		case code.OpGetBuiltin:
+			if in.operands[0] >= v.numBuiltins {
+				return f.errorf(offset, "builtin %d out of range, there are %d",
+					in.operands[0], v.numBuiltins)
+			}
+
+		case code.OpGetFree, code.OpSetFree, code.OpGetFreeCell:
+			// functions that are never closed over can't run
+			numFree, ok := v.numFree[f.index]
+			if f.index < 0 {
+				numFree, ok = 0, true
+			}
+			if ok && in.operands[0] >= numFree {
+				return f.errorf(offset, "free variable %d out of range, the function has %d",
+					in.operands[0], numFree)
+			}
+
+		case code.OpHash:
+			if in.operands[0]%2 != 0 {
+				return f.errorf(offset, "odd number of keys and values: %d", in.operands[0])
+			}
+
+		case code.OpJump, code.OpJumpNotTruthy, code.OpIterNext:
+			target := in.operands[0]
+			if _, ok := f.instructions[target]; !ok && target != len(f.fn.Instructions) {
+				return f.errorf(offset, "jump target %04d is not an instruction", target)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkHandlers makes sure the handlers of f protect whole instructions and
+// continue at one
+func (v *verifier) checkHandlers(f *verifiedFunction) error {
+	isBoundary := func(offset int) bool {
+		_, ok := f.instructions[offset]
+		return ok || offset == len(f.fn.Instructions)
+	}
+
+	for i, h := range f.fn.Handlers {
+		if h.Start > h.End || !isBoundary(h.Start) || !isBoundary(h.End) {
+			return f.errorf(h.Start, "handler %d protects %04d to %04d, which is not a range of instructions",
+				i, h.Start, h.End)
+		}
+		if _, ok := f.instructions[h.Target]; !ok {
+			return f.errorf(h.Start, "handler %d target %04d is not an instruction", i, h.Target)
+		}
+		if h.Depth < 0 {
+			return f.errorf(h.Start, "handler %d keeps %d values", i, h.Depth)
+		}
+	}
+
+	return nil
+}
+
+// checkStack follows every path through f to make sure no instruction pops
+// more values than there are and all paths leading to an instruction leave
+// the same number of values on the stack. Exceptions thrown by a protected
+// instruction lead to its handler with the values the handler keeps and
+// the exception on the stack.
+func (v *verifier) checkStack(f *verifiedFunction) error {
+	depths := map[int]int{0: 0}
+	pending := []int{0}
+	maxDepth := 0
+
+	visit := func(next, depth int) error {
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		d, seen := depths[next]
+		if !seen {
+			depths[next] = depth
+			pending = append(pending, next)
+		} else if d != depth {
+			return f.errorf(next, "stack holds %d or %d values depending on the path taken",
+				d, depth)
+		}
+		return nil
+	}
+
+	for len(pending) > 0 {
+		offset := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		depth := depths[offset]
+
+		in, ok := f.instructions[offset]
+		if !ok {
+			// the end of the instructions, only the main program may
+			// run until there
+			if f.index >= 0 {
+				return f.errorf(offset, "function does not return")
+			}
+			continue
+		}
+
+		// the main program may return a value, which halts the VM
+		if in.op == code.OpReturn && f.index < 0 {
+			return f.errorf(offset, "%s outside of a function", in.def.Name)
+		}
+
+		for _, h := range f.fn.Handlers {
+			if offset < h.Start || offset >= h.End {
+				continue
+			}
+			if depth < h.Depth {
+				return f.errorf(offset, "stack holds %d values, the handler at %04d keeps %d",
+					depth, h.Target, h.Depth)
+			}
+			if err := visit(h.Target, h.Depth+1); err != nil {
+				return err
+			}
+		}
+
+		pops, pushes := stackEffect(in)
+		if depth < pops {
+			return f.errorf(offset, "%s pops %d values, the stack holds %d",
+				in.def.Name, pops, depth)
+		}
+		depth += pushes - pops
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+
+		for i, next := range successors(in) {
+			depth := depth
+			if in.op == code.OpIterNext && i == 1 {
+				// done iterating, the iterator is popped instead of
+				// pushing the next value
+				depth -= 2
+			}
+
+			if err := visit(next, depth); err != nil {
+				return err
+			}
+		}
+	}
+
+	if f.fn.NumLocals+maxDepth > StackSize {
+		return f.errorf(0, "function needs %d stack slots, the stack has %d",
+			f.fn.NumLocals+maxDepth, StackSize)
+	}
+
+	return nil
+}
+
+// stackEffect returns how many values an instruction pops off the stack
+// and how many it pushes
+func stackEffect(in *verifiedInstruction) (int, int) {
+	switch in.op {
+	case code.OpConstant, code.OpNull, code.OpTrue, code.OpFalse,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree,
+		code.OpCurrentClosure, code.OpGetLocalCell, code.OpGetFreeCell:
+		return 0, 1
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual,
+		code.OpLessThan, code.OpLessThanOrEqual, code.OpIndex:
+		return 2, 1
+	case code.OpBang, code.OpMinus, code.OpIter:
+		return 1, 1
+	case code.OpPop, code.OpSetGlobal, code.OpSetLocal, code.OpSetFree,
+		code.OpJumpNotTruthy, code.OpReturnValue, code.OpThrow:
+		return 1, 0
+	case code.OpSetIndex:
+		return 3, 1
+	case code.OpArray, code.OpHash, code.OpInterpolate:
+		return in.operands[0], 1
+	case code.OpCall:
+		// the arguments and the function, replaced by the result
+		return in.operands[0] + 1, 1
+	case code.OpClosure:
+		return in.operands[1], 1
+	case code.OpIterNext:
+		// the iterator stays on the stack, see checkStack for the jump
+		return 1, 2
+	default:
+		return 0, 0
+	}
+}
+
+// successors returns the offsets execution may continue at after in
+func successors(in *verifiedInstruction) []int {
+	switch in.op {
+	case code.OpJump:
+		return []int{in.operands[0]}
+	case code.OpJumpNotTruthy, code.OpIterNext:
+		return []int{in.next, in.operands[0]}
+	case code.OpReturnValue, code.OpReturn, code.OpThrow:
+		return nil
+	default:
+		return []int{in.next}
+	}
+}
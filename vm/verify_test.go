@@ -0,0 +1,239 @@
+package vm
+
+import (
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/object"
+	"testing"
+)
+
+func TestVerifyHandlers(t *testing.T) {
+	// try { throw 1 } catch (e) { e }
+	instructions := concat(
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpThrow),
+		code.Make(code.OpNull),
+		code.Make(code.OpJump, 17),
+		code.Make(code.OpSetGlobal, 0),
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpJump, 17),
+		code.Make(code.OpPop),
+	)
+
+	tests := []struct {
+		instructions code.Instructions
+		handler      code.Handler
+		expected     string
+	}{
+		{
+			instructions,
+			code.Handler{Start: 0, End: 5, Target: 8, Depth: 0},
+			"",
+		},
+		{
+			instructions,
+			code.Handler{Start: 1, End: 5, Target: 8, Depth: 0},
+			"invalid bytecode: <main>, offset 0001: handler 0 protects 0001 to 0005, which is not a range of instructions",
+		},
+		{
+			instructions,
+			code.Handler{Start: 0, End: 5, Target: 9, Depth: 0},
+			"invalid bytecode: <main>, offset 0000: handler 0 target 0009 is not an instruction",
+		},
+		{
+			instructions,
+			code.Handler{Start: 0, End: 5, Target: 8, Depth: 1},
+			"invalid bytecode: <main>, offset 0000: stack holds 0 values, the handler at 0008 keeps 1",
+		},
+		{
+			// the target is reached with and without the exception
+			concat(
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			),
+			code.Handler{Start: 0, End: 3, Target: 4, Depth: 0},
+			"invalid bytecode: <main>, offset 0004: stack holds 1 or 0 values depending on the path taken",
+		},
+	}
+
+	for i, tt := range tests {
+		bytecode := &compiler.Bytecode{
+			Instructions: tt.instructions,
+			Constants:    []object.Object{&object.Integer{Value: 1}},
+			Handlers:     code.Handlers{tt.handler},
+		}
+
+		err := New(bytecode).Verify()
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("test %d: unexpected error: %s", i, err)
+			}
+			continue
+		}
+
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("test %d: wrong error.\nwant=%q\ngot=%v", i, tt.expected, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	fn := func(numLocals int, instructions ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{
+			Instructions: concat(instructions...),
+			NumLocals:    numLocals,
+			Name:         "f",
+		}
+	}
+
+	tests := []struct {
+		instructions [][]byte
+		constants    []object.Object
+		expected     string
+	}{
+		{
+			[][]byte{code.Make(code.OpConstant, 0), code.Make(code.OpPop)},
+			[]object.Object{&object.Integer{Value: 1}},
+			"",
+		},
+		{
+			[][]byte{{255}},
+			nil,
+			"invalid bytecode: <main>, offset 0000: opcode 255 undefined",
+		},
+		{
+			[][]byte{code.Make(code.OpConstant, 0)[:2]},
+			nil,
+			"invalid bytecode: <main>, offset 0000: OpConstant is missing operands",
+		},
+		{
+			[][]byte{code.Make(code.OpConstant, 1), code.Make(code.OpPop)},
+			[]object.Object{&object.Integer{Value: 1}},
+			"invalid bytecode: <main>, offset 0000: constant 1 out of range, the pool holds 1",
+		},
+		{
+			[][]byte{code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)},
+			[]object.Object{&object.Integer{Value: 1}},
+			"invalid bytecode: <main>, offset 0000: constant 0 is not a function: INTEGER",
+		},
+		{
+			[][]byte{code.Make(code.OpGetBuiltin, 100), code.Make(code.OpPop)},
+			nil,
+			"invalid bytecode: <main>, offset 0000: builtin 100 out of range, there are 6",
+		},
+		{
+			[][]byte{code.Make(code.OpGetLocal, 0), code.Make(code.OpPop)},
+			nil,
+			"invalid bytecode: <main>, offset 0000: local 0 out of range, the function has 0",
+		},
+		{
+			[][]byte{code.Make(code.OpTrue), code.Make(code.OpJumpNotTruthy, 2), code.Make(code.OpNull)},
+			nil,
+			"invalid bytecode: <main>, offset 0001: jump target 0002 is not an instruction",
+		},
+		{
+			[][]byte{code.Make(code.OpTrue), code.Make(code.OpAdd), code.Make(code.OpPop)},
+			nil,
+			"invalid bytecode: <main>, offset 0001: OpAdd pops 2 values, the stack holds 1",
+		},
+		{
+			// one branch pushes an extra value
+			[][]byte{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 6),
+				code.Make(code.OpNull),
+				code.Make(code.OpNull),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+			nil,
+			"invalid bytecode: <main>, offset 0006: stack holds 0 or 2 values depending on the path taken",
+		},
+		{
+			[][]byte{code.Make(code.OpTrue), code.Make(code.OpPop), code.Make(code.OpReturn)},
+			nil,
+			"invalid bytecode: <main>, offset 0002: OpReturn outside of a function",
+		},
+		{
+			[][]byte{code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)},
+			[]object.Object{fn(0, code.Make(code.OpNull))},
+			"invalid bytecode: function 0 (f), offset 0001: function does not return",
+		},
+		{
+			[][]byte{code.Make(code.OpNull), code.Make(code.OpClosure, 0, 1), code.Make(code.OpPop)},
+			[]object.Object{fn(0, code.Make(code.OpGetFree, 1), code.Make(code.OpReturnValue))},
+			"invalid bytecode: function 0 (f), offset 0000: free variable 1 out of range, the function has 1",
+		},
+		{
+			[][]byte{code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)},
+			[]object.Object{fn(1, code.Make(code.OpGetLocal, 1), code.Make(code.OpReturnValue))},
+			"invalid bytecode: function 0 (f), offset 0000: local 1 out of range, the function has 1",
+		},
+		{
+			[][]byte{code.Make(code.OpNull), code.Make(code.OpNull), code.Make(code.OpSetIndex)},
+			nil,
+			"invalid bytecode: <main>, offset 0002: OpSetIndex pops 3 values, the stack holds 2",
+		},
+		{
+			// for (x in []) { x }
+			[][]byte{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpIter),
+				code.Make(code.OpIterNext, 11),
+				code.Make(code.OpPop),
+				code.Make(code.OpJump, 4),
+			},
+			nil,
+			"",
+		},
+		{
+			[][]byte{code.Make(code.OpIterNext, 3)},
+			nil,
+			"invalid bytecode: <main>, offset 0000: OpIterNext pops 1 values, the stack holds 0",
+		},
+		{
+			// the values of the iterator are never popped
+			[][]byte{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpIter),
+				code.Make(code.OpIterNext, 10),
+				code.Make(code.OpJump, 4),
+			},
+			nil,
+			"invalid bytecode: <main>, offset 0004: stack holds 1 or 2 values depending on the path taken",
+		},
+	}
+
+	for i, tt := range tests {
+		bytecode := &compiler.Bytecode{
+			Instructions: concat(tt.instructions...),
+			Constants:    tt.constants,
+		}
+
+		err := New(bytecode).Verify()
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("test %d: unexpected error: %s", i, err)
+			}
+			continue
+		}
+
+		if _, ok := err.(*VerifyError); !ok {
+			t.Errorf("test %d: expected *VerifyError, got %T (%v)", i, err, err)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("test %d: wrong error.\nwant=%q\ngot=%q", i, tt.expected, err)
+		}
+	}
+}
+
+func concat(instructions ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+	return out
+}
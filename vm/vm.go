@@ -2,9 +2,11 @@ package vm
 
 import (
 	"fmt"
+	"math"
 	"monkey/code"
 	"monkey/compiler"
 	"monkey/object"
+	"strings"
 )
 
 const StackSize = 2048
@@ -14,9 +16,9 @@ const MaxFrames = 1024
 var (
 	// True , False, Null are Immutable unique values, so we define them globally.
 	// No need to create multiple boolean objects when we can reference one instance.
-	True  = &object.Boolean{Value: true}
-	False = &object.Boolean{Value: false}
-	Null  = &object.Null{}
+	True  = object.TRUE
+	False = object.FALSE
+	Null  = object.NULL
 )
 
 // VM is our virtual machine utilizing a stack machine architecture. It holds a
@@ -27,16 +29,23 @@ type VM struct {
 	stack []object.Object // Our data stack
 	sp    int             // Always points to the next value. Top of stack is stack[sp-1]
 
-	globals []object.Object
+	globals  []object.Object
+	builtins *object.BuiltinRegistry
 
 	frames      []*Frame // Our frame/call stack
 	framesIndex int
+
+	result object.Object // the value the main function popped last
 }
 
 // New initializes our Virtual machine with bytecode
 func New(bytecode *compiler.Bytecode) *VM {
 	// Insert our bytecode into the first stack frame
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
+		Handlers:     bytecode.Handlers,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
@@ -49,7 +58,8 @@ func New(bytecode *compiler.Bytecode) *VM {
 		stack: make([]object.Object, StackSize),
 		sp:    0,
 
-		globals: make([]object.Object, GlobalsSize),
+		globals:  make([]object.Object, GlobalsSize),
+		builtins: object.Builtins,
 
 		frames:      frames,
 		framesIndex: 1,
@@ -64,14 +74,31 @@ func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	return vm
 }
 
+// NewWithBuiltins creates a VM with a pre-existing global store which calls
+// the built-in functions of the given registry. The bytecode must have been
+// compiled against the same registry.
+func NewWithBuiltins(
+	bytecode *compiler.Bytecode,
+	s []object.Object,
+	builtins *object.BuiltinRegistry,
+) *VM {
+	vm := NewWithGlobalsStore(bytecode, s)
+	vm.builtins = builtins
+	return vm
+}
+
 // currentFrame peeks the current frame on our call stack
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("stack overflow")
+	}
 	vm.frames[vm.framesIndex] = f
 	vm.framesIndex++
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -79,8 +106,32 @@ func (vm *VM) popFrame() *Frame {
 	return vm.frames[vm.framesIndex]
 }
 
-// Run initiates our VM's fetch-decode-execute cycle.
-func (vm *VM) Run() error {
+// Run initiates our VM's fetch-decode-execute cycle. Errors are thrown as
+// exceptions the program can catch, uncaught ones are returned as a
+// *RuntimeError holding the Monkey call stack at the point of failure.
+// Go panics, caused by malformed bytecode that wasn't verified or a
+// panicking builtin, are returned as errors as well but can't be caught.
+func (vm *VM) Run() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = vm.runtimeError(fmt.Sprintf("internal error: %v", r))
+		}
+	}()
+
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+
+		exc := vm.exception(err)
+		if !vm.handle(exc) {
+			return &RuntimeError{Message: exc.Message, Pos: exc.Pos, Stack: exc.Stack}
+		}
+	}
+}
+
+func (vm *VM) run() error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
@@ -105,7 +156,7 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
@@ -113,7 +164,10 @@ func (vm *VM) Run() error {
 		case code.OpPop:
 			// instruction we use after expression statements
 			// to keep our stack cleaned up if the expr result isn't used
-			vm.pop()
+			o := vm.pop()
+			if vm.framesIndex == 1 {
+				vm.result = o
+			}
 		case code.OpTrue:
 			err := vm.push(True)
 			if err != nil {
@@ -124,7 +178,8 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpGreaterThan, code.OpNotEqual, code.OpEqual:
+		case code.OpGreaterThan, code.OpGreaterThanOrEqual, code.OpLessThan,
+			code.OpLessThanOrEqual, code.OpNotEqual, code.OpEqual:
 			err := vm.executeComparison(op)
 			if err != nil {
 				return err
@@ -170,7 +225,7 @@ func (vm *VM) Run() error {
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
-			err := vm.push(vm.globals[globalIndex])
+			err := vm.pushVariable(vm.globals[globalIndex])
 			if err != nil {
 				return err
 			}
@@ -182,14 +237,40 @@ func (vm *VM) Run() error {
 
 			// base ptr is right after our func call, localindex is the unique
 			// 0 based index for our local variables.
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+			slot := &vm.stack[frame.basePointer+int(localIndex)]
+			if cell, ok := (*slot).(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				*slot = vm.pop()
+			}
 		case code.OpGetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip++
 
 			currFrame := vm.currentFrame()
 
-			err := vm.push(vm.stack[currFrame.basePointer+int(localIndex)])
+			local := vm.stack[currFrame.basePointer+int(localIndex)]
+			if cell, ok := local.(*object.Cell); ok {
+				local = cell.Value
+			}
+
+			err := vm.pushVariable(local)
+			if err != nil {
+				return err
+			}
+		case code.OpGetLocalCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip++
+
+			// the local is shared with the closure about to capture it
+			slot := &vm.stack[vm.currentFrame().basePointer+int(localIndex)]
+			cell, ok := (*slot).(*object.Cell)
+			if !ok {
+				cell = &object.Cell{Value: *slot}
+				*slot = cell
+			}
+
+			err := vm.push(cell)
 			if err != nil {
 				return err
 			}
@@ -205,6 +286,20 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpInterpolate:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			var out strings.Builder
+			for _, part := range vm.stack[vm.sp-numParts : vm.sp] {
+				out.WriteString(part.Inspect())
+			}
+			vm.sp = vm.sp - numParts
+
+			err := vm.push(&object.String{Value: out.String()})
+			if err != nil {
+				return err
+			}
 		case code.OpHash:
 			numElements := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
@@ -227,9 +322,24 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeIndexAssignment(left, index, value)
+			if err != nil {
+				return err
+			}
 		case code.OpReturnValue:
 			returnValue := vm.pop()
 
+			// returning from the main program halts the VM
+			if vm.framesIndex == 1 {
+				vm.result = returnValue
+				return nil
+			}
+
 			// pop the frame associated w/ the func we executed off the stack
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
@@ -255,12 +365,15 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpGetBuiltin:
-			builtinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip++
+			builtinIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
 
-			definition := object.Builtins[builtinIndex]
+			builtin := vm.builtins.Get(int(builtinIndex))
+			if builtin == nil {
+				return fmt.Errorf("undefined builtin %d", builtinIndex)
+			}
 
-			err := vm.push(definition.Builtin)
+			err := vm.push(builtin)
 			if err != nil {
 				return err
 			}
@@ -281,10 +394,70 @@ func (vm *VM) Run() error {
 			// push the object associated with this free varialbe
 			// onto the stack
 			currentClosure := vm.currentFrame().cl
-			err := vm.push(currentClosure.Free[freeIndex])
+			err := vm.pushVariable(currentClosure.Free[freeIndex].Value)
+			if err != nil {
+				return err
+			}
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip++
+
+			vm.currentFrame().cl.Free[freeIndex].Value = vm.pop()
+		case code.OpGetFreeCell:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip++
+
+			err := vm.push(vm.currentFrame().cl.Free[freeIndex])
 			if err != nil {
 				return err
 			}
+
+		case code.OpCurrentClosure:
+			err := vm.push(vm.currentFrame().cl)
+			if err != nil {
+				return err
+			}
+
+		case code.OpIter:
+			iter, err := object.NewIterator(vm.pop())
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(iter)
+			if err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			iter, ok := vm.stack[vm.sp-1].(*object.Iterator)
+			if !ok {
+				return fmt.Errorf("not an iterator: %s", vm.stack[vm.sp-1].Type())
+			}
+
+			// the iterator is popped once it is done
+			next, ok := iter.Next()
+			if !ok {
+				vm.pop()
+				vm.currentFrame().ip = pos - 1
+				continue
+			}
+
+			err := vm.push(next)
+			if err != nil {
+				return err
+			}
+
+		case code.OpThrow:
+			value := vm.pop()
+
+			// finally blocks pass on the exceptions they intercepted
+			if exc, ok := value.(*object.Error); ok {
+				return &thrown{exc}
+			}
+			return &thrown{vm.newException(value.Inspect(), value)}
 		}
 	}
 	return nil
@@ -298,10 +471,16 @@ func (vm *VM) pushClosure(constIndex int, numFree int) error {
 		return fmt.Errorf("not a function: %+v", constant)
 	}
 
-	// copy our free variables from the stack into our free store
-	free := make([]object.Object, numFree)
+	// move the cells of our free variables from the stack into our free
+	// store, values that aren't shared yet get a cell of their own
+	free := make([]*object.Cell, numFree)
 	for i := 0; i < numFree; i++ {
-		free[i] = vm.stack[vm.sp-numFree+i]
+		value := vm.stack[vm.sp-numFree+i]
+		cell, ok := value.(*object.Cell)
+		if !ok {
+			cell = &object.Cell{Value: value}
+		}
+		free[i] = cell
 	}
 	// clean up the stack
 	vm.sp = vm.sp - numFree
@@ -333,10 +512,21 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	}
 
 	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)
+	if frame.basePointer+cl.Fn.NumLocals > StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
 
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
 
+	// clear what previous calls left in the slots of the locals, so
+	// assigning to a local never changes a stale cell
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
+
 	return nil
 }
 
@@ -348,6 +538,10 @@ func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	// take the arguments and function we executed off the stack
 	vm.sp = vm.sp - numArgs - 1
 
+	if err, ok := result.(*object.Error); ok {
+		return fmt.Errorf("%s", err.Message)
+	}
+
 	if result != nil {
 		vm.push(result)
 	} else {
@@ -363,6 +557,8 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeStringIndex(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
 	default:
@@ -370,6 +566,31 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	}
 }
 
+// executeIndexAssignment changes an element of an array or sets the value
+// of a hash key and pushes the value. Arrays don't grow, their indexes have
+// to be in range.
+func (vm *VM) executeIndexAssignment(left, index, value object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		elements := left.(*object.Array).Elements
+		i := index.(*object.Integer).Value
+		if i < 0 || i >= int64(len(elements)) {
+			return fmt.Errorf("index out of range: %d", i)
+		}
+		elements[i] = value
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		left.(*object.Hash).Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+
+	return vm.push(value)
+}
+
 func (vm *VM) executeArrayIndex(left, index object.Object) error {
 	array := left.(*object.Array)
 	i := index.(*object.Integer).Value
@@ -383,6 +604,19 @@ func (vm *VM) executeArrayIndex(left, index object.Object) error {
 	return vm.push(array.Elements[i])
 }
 
+// executeStringIndex pushes the character at index as a string, strings
+// are indexed by characters instead of bytes
+func (vm *VM) executeStringIndex(left, index object.Object) error {
+	chars := []rune(left.(*object.String).Value)
+	i := index.(*object.Integer).Value
+
+	if i < 0 || i >= int64(len(chars)) {
+		return vm.push(Null)
+	}
+
+	return vm.push(&object.String{Value: string(chars[i])})
+}
+
 func (vm *VM) executeHashIndex(left, index object.Object) error {
 	hash := left.(*object.Hash)
 
@@ -446,12 +680,14 @@ func (vm *VM) executeBangOperator() error {
 }
 
 func (vm *VM) executeMinusOperator() error {
-	operand := vm.pop()
-	if operand.Type() != object.INTEGER_OBJ {
+	switch operand := vm.pop().(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: -operand.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
 }
 
 // executeComparison executes a comparison based on the type of the operands
@@ -460,7 +696,10 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if left.Type() == object.INTEGER_OBJ || right.Type() == object.INTEGER_OBJ {
+	if isNumber(left) && isNumber(right) {
+		if left.Type() == object.FLOAT_OBJ || right.Type() == object.FLOAT_OBJ {
+			return vm.executeFloatComparison(op, left, right)
+		}
 		return vm.executeIntegerComparison(op, left, right)
 	}
 
@@ -470,11 +709,22 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	case code.OpNotEqual:
 		return vm.push(nativeBoolToBooleanObject(left != right))
 	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)",
-			op, left.Type(), right.Type())
+		return fmt.Errorf("unknown operator: %s %s %s",
+			left.Type(), comparisonOperators[op], right.Type())
 	}
 }
 
+// comparisonOperators are the operators the comparison opcodes implement,
+// errors name them like the evaluator does
+var comparisonOperators = map[code.Opcode]string{
+	code.OpEqual:              "==",
+	code.OpNotEqual:           "!=",
+	code.OpGreaterThan:        ">",
+	code.OpGreaterThanOrEqual: ">=",
+	code.OpLessThan:           "<",
+	code.OpLessThanOrEqual:    "<=",
+}
+
 // executeIntegerComparison compares two integers and returns the result
 func (vm *VM) executeIntegerComparison(
 	op code.Opcode,
@@ -490,11 +740,55 @@ func (vm *VM) executeIntegerComparison(
 		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpLessThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
 	default:
-		return fmt.Errorf("unknown operator: %d", op)
+		return fmt.Errorf("unknown operator: %s", comparisonOperators[op])
 	}
 }
 
+// executeFloatComparison compares two numbers of which at least one is a
+// float, the other one is converted to a float
+func (vm *VM) executeFloatComparison(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpLessThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %s", comparisonOperators[op])
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	if i, ok := obj.(*object.Integer); ok {
+		return float64(i.Value)
+	}
+	return obj.(*object.Float).Value
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return True
@@ -513,6 +807,8 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 		return vm.executeBinaryStringOperation(op, left, right)
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeBinaryFloatOperation(op, left, right)
 	default:
 		return fmt.Errorf("unsupported types for binary operation: %s %s",
 			leftType, rightType)
@@ -552,12 +848,50 @@ func (vm *VM) executeBinaryIntegerOperation(
 	case code.OpMul:
 		result = leftValue * rightValue
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		if leftValue == math.MinInt64 && rightValue == -1 {
+			return fmt.Errorf("integer overflow: %d / %d", leftValue, rightValue)
+		}
 		result = leftValue / rightValue
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftValue % rightValue
 	}
 
 	return vm.push(&object.Integer{Value: result})
 }
 
+// executeBinaryFloatOperation performs arithmetic on two numbers of which
+// at least one is a float, the result is always a float
+func (vm *VM) executeBinaryFloatOperation(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+
+	var result float64
+
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		result = leftValue / rightValue
+	case code.OpMod:
+		result = math.Mod(leftValue, rightValue)
+	}
+
+	return vm.push(&object.Float{Value: result})
+}
+
 func (vm *VM) push(o object.Object) error {
 	if vm.sp >= StackSize {
 		return fmt.Errorf("stack overflow")
@@ -569,6 +903,16 @@ func (vm *VM) push(o object.Object) error {
 	return nil
 }
 
+// pushVariable pushes the value of a variable. Variables defined by a let
+// statement that never ran, like one in a branch that wasn't taken, are
+// unset and read as null.
+func (vm *VM) pushVariable(o object.Object) error {
+	if o == nil {
+		o = Null
+	}
+	return vm.push(o)
+}
+
 func (vm *VM) pop() object.Object {
 	o := vm.stack[vm.sp-1]
 	vm.sp--
@@ -585,6 +929,16 @@ func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.sp-1]
 }
 
+// Result returns the result of the program after a successful Run, the
+// value of its last expression statement. Programs that end with a let
+// statement or a loop result in null.
+func (vm *VM) Result() object.Object {
+	if vm.result == nil {
+		return Null
+	}
+	return vm.result
+}
+
 // LastPoppedStackElem is a test only method which returns the top of the stack.
 // Since we don't explicitly clear the stack off after we use it, we can see
 // the item that was last popped off the stack here.